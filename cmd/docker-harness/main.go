@@ -0,0 +1,326 @@
+/*
+docker-harness starts environments described by spec files, and lists
+and cleans up the docker resources that the docker-harness library has
+created, such as containers left running by an interrupted test run.
+
+	docker-harness up spec.yaml
+	docker-harness ls
+	docker-harness prune --older-than 1h
+	docker-harness logs <name>
+	docker-harness inspect <name>
+*/
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"os/signal"
+	"sort"
+	"syscall"
+	"text/tabwriter"
+	"time"
+
+	"github.com/docker/docker/api/types/container"
+	"github.com/docker/docker/api/types/filters"
+	"github.com/docker/docker/api/types/network"
+	docker "github.com/docker/docker/client"
+	"github.com/docker/docker/pkg/stdcopy"
+	harness "github.com/hlfshell/docker-harness"
+)
+
+const usage = `usage: docker-harness <command> [arguments]
+
+commands:
+  up <spec>                 start the environment described by a spec file until interrupted
+  ls                        list harness-created containers, networks, volumes and compose projects
+  prune [--older-than 1h]   remove harness-created resources older than the given age (1h by default),
+                            leaving shared containers still in use unless --include-shared is given
+  logs [--follow] <name>    print the logs of a container or compose project; --follow is for containers only
+  inspect <name>            print docker's inspect output for a container, network or volume
+`
+
+func main() {
+	os.Exit(run(context.Background(), os.Args[1:], os.Stdout, os.Stderr))
+}
+
+func run(ctx context.Context, args []string, stdout io.Writer, stderr io.Writer) int {
+	if len(args) == 0 {
+		fmt.Fprint(stderr, usage)
+		return 2
+	}
+
+	commands := map[string]func(context.Context, *docker.Client, []string, io.Writer, io.Writer) error{
+		"up":      up,
+		"ls":      list,
+		"prune":   prune,
+		"logs":    logs,
+		"inspect": inspect,
+	}
+	command, ok := commands[args[0]]
+	if !ok {
+		fmt.Fprintf(stderr, "unknown command %q\n\n%s", args[0], usage)
+		return 2
+	}
+
+	client, err := docker.NewClientWithOpts(docker.FromEnv, docker.WithAPIVersionNegotiation())
+	if err != nil {
+		fmt.Fprintf(stderr, "docker-harness: %v\n", err)
+		return 1
+	}
+	defer client.Close()
+
+	if err := command(ctx, client, args[1:], stdout, stderr); err != nil {
+		if errors.Is(err, flag.ErrHelp) {
+			return 2
+		}
+		fmt.Fprintf(stderr, "docker-harness %s: %v\n", args[0], err)
+		return 1
+	}
+
+	return 0
+}
+
+func up(ctx context.Context, client *docker.Client, args []string, stdout io.Writer, stderr io.Writer) error {
+	flags := flag.NewFlagSet("up", flag.ContinueOnError)
+	flags.SetOutput(stderr)
+	if err := flags.Parse(args); err != nil {
+		return err
+	}
+	if flags.NArg() != 1 {
+		return errors.New("expected a single spec file")
+	}
+
+	stack, err := harness.LoadSpec(flags.Arg(0))
+	if err != nil {
+		return err
+	}
+
+	ctx, stop := signal.NotifyContext(ctx, os.Interrupt, syscall.SIGTERM)
+	defer stop()
+
+	fmt.Fprintf(stdout, "starting %s\n", stack.GetName())
+	if err := stack.Start(); err != nil {
+		return errors.Join(err, stack.Cleanup())
+	}
+
+	// Show where each container's ports ended up on the host
+	table := tabwriter.NewWriter(stdout, 0, 0, 3, ' ', 0)
+	fmt.Fprintln(table, "CONTAINER\tPORT\tADDRESS")
+	containers := stack.GetContainers()
+	for _, name := range sortedNames(containers) {
+		ports := containers[name].GetPorts()
+		for _, port := range sortedNames(ports) {
+			fmt.Fprintf(table, "%s\t%s\t127.0.0.1:%s\n", name, port, ports[port])
+		}
+	}
+	table.Flush()
+
+	fmt.Fprintln(stdout, "running; press Ctrl-C to stop and clean up")
+	<-ctx.Done()
+
+	fmt.Fprintf(stdout, "cleaning up %s\n", stack.GetName())
+	return stack.Cleanup()
+}
+
+func list(ctx context.Context, client *docker.Client, args []string, stdout io.Writer, stderr io.Writer) error {
+	flags := flag.NewFlagSet("ls", flag.ContinueOnError)
+	flags.SetOutput(stderr)
+	if err := flags.Parse(args); err != nil {
+		return err
+	}
+
+	resources, err := listResources(ctx, client)
+	if err != nil {
+		return err
+	}
+
+	writeResources(stdout, resources, time.Now())
+	return nil
+}
+
+func prune(ctx context.Context, client *docker.Client, args []string, stdout io.Writer, stderr io.Writer) error {
+	flags := flag.NewFlagSet("prune", flag.ContinueOnError)
+	flags.SetOutput(stderr)
+	// Resources younger than this may belong to tests that are still
+	// running, so everything is only removed if asked for with 0
+	age := flags.Duration("older-than", time.Hour, "only remove resources created at least this long ago; 0 removes everything")
+	dryRun := flags.Bool("dry-run", false, "list what would be removed without removing it")
+	includeShared := flags.Bool("include-shared", false, "also remove shared containers that running processes still hold")
+	if err := flags.Parse(args); err != nil {
+		return err
+	}
+
+	resources, err := listResources(ctx, client)
+	if err != nil {
+		return err
+	}
+	resources = olderThan(resources, *age, time.Now())
+
+	// Shared containers can outlive any one test run, so their age says
+	// nothing about whether they are still in use
+	if !*includeShared {
+		resources, err = unheld(resources, harness.SharedContainerHeld, stdout)
+		if err != nil {
+			return err
+		}
+	}
+
+	return pruneResources(resources, *dryRun, stdout, func(r *resource) error {
+		return removeResource(ctx, client, r)
+	})
+}
+
+// pruneResources removes the resources with remove, or only reports what
+// would be removed on a dry run. Containers and compose projects are
+// removed first, so that networks and volumes are no longer in use by
+// the time they are removed.
+func pruneResources(resources []*resource, dryRun bool, stdout io.Writer, remove func(*resource) error) error {
+	errs := []error{}
+	for _, kinds := range [][]string{{kindContainer, kindCompose}, {kindNetwork, kindVolume}} {
+		for _, r := range resources {
+			if r.Kind != kinds[0] && r.Kind != kinds[1] {
+				continue
+			}
+			if dryRun {
+				fmt.Fprintf(stdout, "would remove %s %s\n", r.Kind, r.Name)
+				continue
+			}
+			if err := remove(r); err != nil {
+				errs = append(errs, fmt.Errorf("%s %s: %w", r.Kind, r.Name, err))
+				continue
+			}
+			fmt.Fprintf(stdout, "removed %s %s\n", r.Kind, r.Name)
+		}
+	}
+
+	return errors.Join(errs...)
+}
+
+func logs(ctx context.Context, client *docker.Client, args []string, stdout io.Writer, stderr io.Writer) error {
+	flags := flag.NewFlagSet("logs", flag.ContinueOnError)
+	flags.SetOutput(stderr)
+	follow := flags.Bool("follow", false, "keep streaming new log output")
+	tail := flags.String("tail", "all", "number of lines to show from the end of the logs")
+	if err := flags.Parse(args); err != nil {
+		return err
+	}
+	if flags.NArg() != 1 {
+		return errors.New("expected a single container or compose project name")
+	}
+	name := flags.Arg(0)
+
+	options := container.LogsOptions{
+		ShowStdout: true,
+		ShowStderr: true,
+		Follow:     *follow,
+		Tail:       *tail,
+	}
+
+	// A compose project's logs are those of each of its containers
+	ids := []string{name}
+	if isHarnessProject(name) {
+		containers, err := client.ContainerList(ctx, container.ListOptions{
+			All:     true,
+			Filters: filters.NewArgs(filters.Arg("label", fmt.Sprintf("%s=%s", composeProjectLabel, name))),
+		})
+		if err != nil {
+			return err
+		}
+		if len(containers) > 0 {
+			if *follow {
+				return fmt.Errorf("--follow is not supported for compose projects; use docker compose --project-name %s logs --follow", name)
+			}
+			ids = []string{}
+			for _, c := range containers {
+				ids = append(ids, c.ID)
+			}
+		}
+	}
+
+	for _, id := range ids {
+		if len(ids) > 1 {
+			fmt.Fprintf(stdout, "==> %s <==\n", id[:12])
+		}
+
+		out, err := client.ContainerLogs(ctx, id, options)
+		if err != nil {
+			return err
+		}
+		_, err = stdcopy.StdCopy(stdout, stderr, out)
+		out.Close()
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+func inspect(ctx context.Context, client *docker.Client, args []string, stdout io.Writer, stderr io.Writer) error {
+	flags := flag.NewFlagSet("inspect", flag.ContinueOnError)
+	flags.SetOutput(stderr)
+	if err := flags.Parse(args); err != nil {
+		return err
+	}
+	if flags.NArg() != 1 {
+		return errors.New("expected a single container, network or volume name")
+	}
+	name := flags.Arg(0)
+
+	// Try each kind of resource in turn
+	var result any
+	if c, err := client.ContainerInspect(ctx, name); err == nil {
+		result = c
+	} else if !docker.IsErrNotFound(err) {
+		return err
+	} else if n, err := client.NetworkInspect(ctx, name, network.InspectOptions{}); err == nil {
+		result = n
+	} else if !docker.IsErrNotFound(err) {
+		return err
+	} else if v, err := client.VolumeInspect(ctx, name); err == nil {
+		result = v
+	} else if !docker.IsErrNotFound(err) {
+		return err
+	} else {
+		return fmt.Errorf("no container, network or volume named %s", name)
+	}
+
+	encoder := json.NewEncoder(stdout)
+	encoder.SetIndent("", "  ")
+	return encoder.Encode(result)
+}
+
+func sortedNames[V any](m map[string]V) []string {
+	names := make([]string, 0, len(m))
+	for name := range m {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+func writeResources(w io.Writer, resources []*resource, now time.Time) {
+	table := tabwriter.NewWriter(w, 0, 0, 3, ' ', 0)
+	fmt.Fprintln(table, "KIND\tNAME\tAGE\tSESSION\tSTATUS")
+	for _, r := range resources {
+		age := "-"
+		if !r.Created.IsZero() {
+			age = formatAge(now.Sub(r.Created))
+		}
+		session := r.Session
+		if session == "" {
+			session = "-"
+		}
+		status := r.Status
+		if status == "" {
+			status = "-"
+		}
+		fmt.Fprintf(table, "%s\t%s\t%s\t%s\t%s\n", r.Kind, r.Name, age, session, status)
+	}
+	table.Flush()
+}
@@ -0,0 +1,134 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	harness "github.com/hlfshell/docker-harness"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestRunUsage(t *testing.T) {
+	stdout := &bytes.Buffer{}
+	stderr := &bytes.Buffer{}
+
+	code := run(context.Background(), []string{}, stdout, stderr)
+	assert.Equal(t, 2, code)
+	assert.Contains(t, stderr.String(), "usage: docker-harness")
+
+	stderr.Reset()
+	code = run(context.Background(), []string{"explode"}, stdout, stderr)
+	assert.Equal(t, 2, code)
+	assert.Contains(t, stderr.String(), `unknown command "explode"`)
+}
+
+func TestOlderThan(t *testing.T) {
+	now := time.Now()
+	resources := []*resource{
+		{Kind: kindContainer, Name: "new", Created: now.Add(-time.Minute)},
+		{Kind: kindCompose, Name: "old", Created: now.Add(-2 * time.Hour)},
+		{Kind: kindVolume, Name: "ancient", Created: now.Add(-48 * time.Hour)},
+	}
+
+	old := olderThan(resources, time.Hour, now)
+	require.Len(t, old, 2)
+	assert.Equal(t, "old", old[0].Name)
+	assert.Equal(t, "ancient", old[1].Name)
+
+	// No age means everything
+	assert.Len(t, olderThan(resources, 0, now), 3)
+}
+
+func TestPruneResources(t *testing.T) {
+	resources := []*resource{
+		{Kind: kindVolume, Name: "data"},
+		{Kind: kindContainer, Name: "db"},
+		{Kind: kindNetwork, Name: "net"},
+	}
+
+	// A dry run removes nothing, and says so
+	out := &bytes.Buffer{}
+	err := pruneResources(resources, true, out, func(r *resource) error {
+		t.Fatalf("%s %s was removed on a dry run", r.Kind, r.Name)
+		return nil
+	})
+	require.Nil(t, err)
+	assert.Equal(t, "would remove container db\nwould remove volume data\nwould remove network net\n", out.String())
+
+	// Containers go first, and failures are reported without stopping
+	out.Reset()
+	removed := []string{}
+	err = pruneResources(resources, false, out, func(r *resource) error {
+		if r.Name == "net" {
+			return errors.New("in use")
+		}
+		removed = append(removed, r.Name)
+		return nil
+	})
+	require.NotNil(t, err)
+	assert.Contains(t, err.Error(), "network net: in use")
+	assert.Equal(t, []string{"db", "data"}, removed)
+	assert.Equal(t, "removed container db\nremoved volume data\n", out.String())
+}
+
+func TestUnheld(t *testing.T) {
+	resources := []*resource{
+		{Kind: kindContainer, Name: "held", Shared: "in-use"},
+		{Kind: kindContainer, Name: "released", Shared: "done"},
+		{Kind: kindContainer, Name: "plain"},
+	}
+	held := func(key string) (bool, error) {
+		return key == "in-use", nil
+	}
+
+	out := &bytes.Buffer{}
+	remaining, err := unheld(resources, held, out)
+	require.Nil(t, err)
+	require.Len(t, remaining, 2)
+	assert.Equal(t, "released", remaining[0].Name)
+	assert.Equal(t, "plain", remaining[1].Name)
+	assert.Equal(t, "skipping shared container held, still in use\n", out.String())
+
+	_, err = unheld(resources, func(string) (bool, error) { return false, errors.New("locked") }, out)
+	require.NotNil(t, err)
+}
+
+func TestIsHarnessProject(t *testing.T) {
+	assert.True(t, isHarnessProject(harness.ComposeProjectPrefix+"1234-5678"))
+	assert.False(t, isHarnessProject("my-test-stack"))
+}
+
+func TestFormatAge(t *testing.T) {
+	assert.Equal(t, "42s", formatAge(42*time.Second))
+	assert.Equal(t, "5m", formatAge(5*time.Minute+10*time.Second))
+	assert.Equal(t, "3h", formatAge(3*time.Hour+59*time.Minute))
+	assert.Equal(t, "2d", formatAge(50*time.Hour))
+}
+
+func TestWriteResources(t *testing.T) {
+	now := time.Now()
+	out := &bytes.Buffer{}
+	writeResources(out, []*resource{
+		{
+			Kind:    kindContainer,
+			Name:    "TestPostgres",
+			Created: now.Add(-90 * time.Minute),
+			Session: "123-456",
+			Status:  "running",
+		},
+		{
+			Kind: kindNetwork,
+			Name: "docker-harness-1-2",
+		},
+	}, now)
+
+	lines := bytes.Split(bytes.TrimSpace(out.Bytes()), []byte("\n"))
+	require.Len(t, lines, 3)
+	assert.Contains(t, string(lines[0]), "KIND")
+	assert.Regexp(t, `container\s+TestPostgres\s+1h\s+123-456\s+running`, string(lines[1]))
+	assert.Regexp(t, `network\s+docker-harness-1-2\s+-\s+-\s+-`, string(lines[2]))
+}
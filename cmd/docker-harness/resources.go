@@ -0,0 +1,283 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/docker/docker/api/types/container"
+	"github.com/docker/docker/api/types/filters"
+	"github.com/docker/docker/api/types/network"
+	"github.com/docker/docker/api/types/volume"
+	docker "github.com/docker/docker/client"
+	harness "github.com/hlfshell/docker-harness"
+)
+
+const composeProjectLabel = "com.docker.compose.project"
+
+const (
+	kindContainer = "container"
+	kindNetwork   = "network"
+	kindVolume    = "volume"
+	kindCompose   = "compose"
+)
+
+// resource is anything the harness has left behind. Compose projects
+// are a single resource made up of their containers, networks and
+// volumes.
+type resource struct {
+	Kind    string
+	Name    string
+	ID      string
+	Created time.Time
+	Session string
+	Status  string
+	// Shared is the key of a shared container, if it is one
+	Shared string
+
+	containers []string
+	networks   []string
+	volumes    []string
+}
+
+// listResources finds every harness-created resource: anything carrying
+// the harness's managed label, and every compose project whose name was
+// generated by the harness.
+func listResources(ctx context.Context, client *docker.Client) ([]*resource, error) {
+	resources := []*resource{}
+	projects := map[string]*resource{}
+	project := func(name string) *resource {
+		if projects[name] == nil {
+			projects[name] = &resource{Kind: kindCompose, Name: name}
+		}
+		return projects[name]
+	}
+
+	managed := filters.NewArgs(filters.Arg("label", harness.LabelManaged))
+	composed := filters.NewArgs(filters.Arg("label", composeProjectLabel))
+
+	containers, err := client.ContainerList(ctx, container.ListOptions{All: true, Filters: managed})
+	if err != nil {
+		return nil, fmt.Errorf("failed to list containers: %w", err)
+	}
+	for _, c := range containers {
+		resources = append(resources, &resource{
+			Kind:    kindContainer,
+			Name:    containerName(c.Names, c.ID),
+			ID:      c.ID,
+			Created: time.Unix(c.Created, 0),
+			Session: c.Labels[harness.LabelSession],
+			Status:  c.State,
+			Shared:  c.Labels[harness.LabelShared],
+		})
+	}
+
+	containers, err = client.ContainerList(ctx, container.ListOptions{All: true, Filters: composed})
+	if err != nil {
+		return nil, fmt.Errorf("failed to list compose containers: %w", err)
+	}
+	for _, c := range containers {
+		name := c.Labels[composeProjectLabel]
+		if !isHarnessProject(name) {
+			continue
+		}
+		p := project(name)
+		p.containers = append(p.containers, c.ID)
+		p.observe(time.Unix(c.Created, 0))
+		if c.State == "running" {
+			p.Status = "running"
+		}
+	}
+
+	networks, err := client.NetworkList(ctx, network.ListOptions{Filters: managed})
+	if err != nil {
+		return nil, fmt.Errorf("failed to list networks: %w", err)
+	}
+	for _, n := range networks {
+		resources = append(resources, &resource{
+			Kind:    kindNetwork,
+			Name:    n.Name,
+			ID:      n.ID,
+			Created: n.Created,
+			Session: n.Labels[harness.LabelSession],
+		})
+	}
+
+	networks, err = client.NetworkList(ctx, network.ListOptions{Filters: composed})
+	if err != nil {
+		return nil, fmt.Errorf("failed to list compose networks: %w", err)
+	}
+	for _, n := range networks {
+		name := n.Labels[composeProjectLabel]
+		if !isHarnessProject(name) {
+			continue
+		}
+		p := project(name)
+		p.networks = append(p.networks, n.ID)
+		p.observe(n.Created)
+	}
+
+	volumes, err := client.VolumeList(ctx, volume.ListOptions{Filters: managed})
+	if err != nil {
+		return nil, fmt.Errorf("failed to list volumes: %w", err)
+	}
+	for _, v := range volumes.Volumes {
+		resources = append(resources, &resource{
+			Kind:    kindVolume,
+			Name:    v.Name,
+			ID:      v.Name,
+			Created: parseVolumeTime(v.CreatedAt),
+			Session: v.Labels[harness.LabelSession],
+		})
+	}
+
+	volumes, err = client.VolumeList(ctx, volume.ListOptions{Filters: composed})
+	if err != nil {
+		return nil, fmt.Errorf("failed to list compose volumes: %w", err)
+	}
+	for _, v := range volumes.Volumes {
+		name := v.Labels[composeProjectLabel]
+		if !isHarnessProject(name) {
+			continue
+		}
+		p := project(name)
+		p.volumes = append(p.volumes, v.Name)
+		p.observe(parseVolumeTime(v.CreatedAt))
+	}
+
+	for _, p := range projects {
+		if p.Status == "" {
+			p.Status = "stopped"
+		}
+		p.Status = fmt.Sprintf("%s (%d containers)", p.Status, len(p.containers))
+		resources = append(resources, p)
+	}
+
+	sort.Slice(resources, func(i, j int) bool {
+		return resources[i].Created.Before(resources[j].Created)
+	})
+
+	return resources, nil
+}
+
+// removeResource removes a resource, including everything belonging to
+// a compose project. Containers are removed before the networks and
+// volumes they use.
+func removeResource(ctx context.Context, client *docker.Client, r *resource) error {
+	switch r.Kind {
+	case kindContainer:
+		return removeContainer(ctx, client, r.ID)
+	case kindNetwork:
+		return ignoreNotFound(client.NetworkRemove(ctx, r.ID))
+	case kindVolume:
+		return ignoreNotFound(client.VolumeRemove(ctx, r.ID, true))
+	case kindCompose:
+		errs := []error{}
+		for _, id := range r.containers {
+			errs = append(errs, removeContainer(ctx, client, id))
+		}
+		for _, id := range r.networks {
+			errs = append(errs, ignoreNotFound(client.NetworkRemove(ctx, id)))
+		}
+		for _, name := range r.volumes {
+			errs = append(errs, ignoreNotFound(client.VolumeRemove(ctx, name, true)))
+		}
+		return errors.Join(errs...)
+	}
+
+	return fmt.Errorf("unknown resource kind %s", r.Kind)
+}
+
+func removeContainer(ctx context.Context, client *docker.Client, id string) error {
+	return ignoreNotFound(client.ContainerRemove(ctx, id, container.RemoveOptions{
+		Force:         true,
+		RemoveVolumes: true,
+	}))
+}
+
+func ignoreNotFound(err error) error {
+	if err != nil && docker.IsErrNotFound(err) {
+		return nil
+	}
+	return err
+}
+
+// observe tracks the earliest creation time of a compose project's parts
+// as the project's creation time.
+func (r *resource) observe(created time.Time) {
+	if created.IsZero() {
+		return
+	}
+	if r.Created.IsZero() || created.Before(r.Created) {
+		r.Created = created
+	}
+}
+
+// olderThan filters resources down to those created longer ago than age.
+func olderThan(resources []*resource, age time.Duration, now time.Time) []*resource {
+	old := []*resource{}
+	for _, r := range resources {
+		if now.Sub(r.Created) >= age {
+			old = append(old, r)
+		}
+	}
+	return old
+}
+
+// unheld filters out shared containers that a live process still holds,
+// reporting each one left alone.
+func unheld(resources []*resource, held func(key string) (bool, error), w io.Writer) ([]*resource, error) {
+	remaining := []*resource{}
+	for _, r := range resources {
+		if r.Shared != "" {
+			inUse, err := held(r.Shared)
+			if err != nil {
+				return nil, fmt.Errorf("failed to check shared container %s: %w", r.Name, err)
+			}
+			if inUse {
+				fmt.Fprintf(w, "skipping shared container %s, still in use\n", r.Name)
+				continue
+			}
+		}
+		remaining = append(remaining, r)
+	}
+	return remaining, nil
+}
+
+func isHarnessProject(name string) bool {
+	return strings.HasPrefix(name, harness.ComposeProjectPrefix)
+}
+
+func containerName(names []string, id string) string {
+	if len(names) == 0 {
+		return id
+	}
+	return strings.TrimPrefix(names[0], "/")
+}
+
+func parseVolumeTime(value string) time.Time {
+	created, err := time.Parse(time.RFC3339, value)
+	if err != nil {
+		return time.Time{}
+	}
+	return created
+}
+
+// formatAge renders a duration the way docker does for ages, using its
+// largest whole unit.
+func formatAge(age time.Duration) string {
+	switch {
+	case age < time.Minute:
+		return fmt.Sprintf("%ds", int(age.Seconds()))
+	case age < time.Hour:
+		return fmt.Sprintf("%dm", int(age.Minutes()))
+	case age < 24*time.Hour:
+		return fmt.Sprintf("%dh", int(age.Hours()))
+	default:
+		return fmt.Sprintf("%dd", int(age.Hours()/24))
+	}
+}
@@ -8,8 +8,13 @@ import (
 	"fmt"
 	"io"
 	"math/rand/v2"
+	"net"
 	"os"
 	"os/exec"
+	"path/filepath"
+	"regexp"
+	"slices"
+	"sort"
 	"strconv"
 	"strings"
 	"sync"
@@ -18,9 +23,23 @@ import (
 
 const defaultComposeWaitTimeout = 60 * time.Second
 
+// DiagnosticsDirEnv can be set to collect diagnostics for every compose
+// project that fails to start, such as in CI, without setting
+// ComposeOptions.DiagnosticsDir.
+const DiagnosticsDirEnv = "DOCKER_HARNESS_DIAGNOSTICS_DIR"
+
 type ComposeOptions struct {
-	Name          string
-	Files         []string
+	Name  string
+	Files []string
+	// Sources are compose files given as content rather than paths,
+	// applied after Files
+	Sources []ComposeSource
+	// BaseDir is the project directory, like --project-directory, that
+	// relative paths in every compose file, Files as well as Sources,
+	// such as build contexts and bind mounts, resolve against. It
+	// defaults to the directory of the first of Files, or WorkDir
+	// without Files.
+	BaseDir       string
 	WorkDir       string
 	Env           map[string]string
 	Profiles      []string
@@ -33,26 +52,47 @@ type ComposeOptions struct {
 	KeepVolumes   bool
 	RemoveOrphans bool
 	KeepOrphans   bool
-	Stdout        io.Writer
-	Stderr        io.Writer
+	// Scale sets how many replicas of a service Start runs
+	Scale map[string]int
+	// Override is applied on top of the compose files
+	Override *ComposeOverride
+	// WaitFor are checked for each service, once it is up, for services
+	// that are not ready as soon as they are running or healthy. The
+	// services are checked concurrently, within WaitTimeout.
+	WaitFor map[string][]WaitStrategy
+	// DiagnosticsDir, when set, makes a failed Start collect diagnostics
+	// into a new directory within it named after the project and the
+	// time of the failure. It defaults to DiagnosticsDirEnv.
+	DiagnosticsDir string
+	Stdout         io.Writer
+	Stderr         io.Writer
 }
 
 type Compose struct {
-	name          string
-	files         []string
-	workDir       string
-	env           map[string]string
-	profiles      []string
-	services      []string
-	wait          bool
-	waitTimeout   time.Duration
-	build         bool
-	pull          string
-	keepVolumes   bool
-	removeOrphans bool
-	stdout        io.Writer
-	stderr        io.Writer
-	command       []string
+	name           string
+	files          []string
+	workDir        string
+	env            map[string]string
+	profiles       []string
+	services       []string
+	wait           bool
+	waitTimeout    time.Duration
+	build          bool
+	pull           string
+	keepVolumes    bool
+	removeOrphans  bool
+	scale          map[string]int
+	waitFor        map[string][]WaitStrategy
+	diagnosticsDir string
+	projectDir     string
+	// generated are the contents of Sources and the Override, which are
+	// written to tempFiles in a private temporary directory
+	generated [][]byte
+	tempDir   string
+	tempFiles []string
+	stdout    io.Writer
+	stderr    io.Writer
+	command   []string
 
 	lock sync.Mutex
 }
@@ -66,6 +106,23 @@ type ComposeContainer struct {
 	State    string `json:"State"`
 	Health   string `json:"Health"`
 	ExitCode int    `json:"ExitCode"`
+	Labels   string `json:"Labels"`
+	// Index is the container's replica number within its service,
+	// starting from 1
+	Index int `json:"-"`
+
+	Publishers []ComposePublisher `json:"Publishers"`
+}
+
+/*
+ComposePublisher is a port a compose container publishes on the host.
+PublishedPort is zero for ports that are exposed but not published.
+*/
+type ComposePublisher struct {
+	URL           string `json:"URL"`
+	TargetPort    int    `json:"TargetPort"`
+	PublishedPort int    `json:"PublishedPort"`
+	Protocol      string `json:"Protocol"`
 }
 
 /*
@@ -91,7 +148,7 @@ NewComposeWithOptions will create a new docker compose harness with
 additional docker compose options.
 */
 func NewComposeWithOptions(options ComposeOptions) (*Compose, error) {
-	if len(options.Files) == 0 {
+	if len(options.Files) == 0 && len(options.Sources) == 0 {
 		return nil, errors.New("at least one compose file is required")
 	}
 	for _, file := range options.Files {
@@ -130,23 +187,88 @@ func NewComposeWithOptions(options ComposeOptions) (*Compose, error) {
 		removeOrphans = true
 	}
 
-	return &Compose{
-		name:          name,
-		files:         options.Files,
-		workDir:       workDir,
-		env:           options.Env,
-		profiles:      options.Profiles,
-		services:      options.Services,
-		wait:          wait,
-		waitTimeout:   waitTimeout,
-		build:         options.Build,
-		pull:          options.Pull,
-		keepVolumes:   options.KeepVolumes,
-		removeOrphans: removeOrphans,
-		stdout:        options.Stdout,
-		stderr:        options.Stderr,
-		command:       command,
-	}, nil
+	scale := map[string]int{}
+	for service, replicas := range options.Scale {
+		if replicas < 0 {
+			return nil, fmt.Errorf("service %s cannot be scaled to %d replicas", service, replicas)
+		}
+		scale[service] = replicas
+	}
+
+	waitFor := map[string][]WaitStrategy{}
+	for service, strategies := range options.WaitFor {
+		if service == "" {
+			return nil, errors.New("wait strategy service name cannot be blank")
+		}
+		for _, strategy := range strategies {
+			if strategy == nil {
+				return nil, fmt.Errorf("wait strategy for service %s cannot be nil", service)
+			}
+		}
+		waitFor[service] = strategies
+	}
+
+	diagnosticsDir := options.DiagnosticsDir
+	if diagnosticsDir == "" {
+		diagnosticsDir = os.Getenv(DiagnosticsDirEnv)
+	}
+
+	generated := [][]byte{}
+	for _, source := range options.Sources {
+		content, err := source.read()
+		if err != nil {
+			return nil, err
+		}
+		generated = append(generated, content)
+	}
+	if options.Override != nil {
+		override, err := options.Override.render()
+		if err != nil {
+			return nil, err
+		}
+		generated = append(generated, override)
+	}
+
+	// Generated files live in a temporary directory, so relative paths
+	// in them need a project directory to resolve against
+	projectDir := options.BaseDir
+	if projectDir == "" && len(options.Files) == 0 {
+		projectDir, err = filepath.Abs(workDir)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	c := &Compose{
+		name:           name,
+		files:          options.Files,
+		workDir:        workDir,
+		env:            options.Env,
+		profiles:       options.Profiles,
+		services:       options.Services,
+		wait:           wait,
+		waitTimeout:    waitTimeout,
+		build:          options.Build,
+		pull:           options.Pull,
+		keepVolumes:    options.KeepVolumes,
+		removeOrphans:  removeOrphans,
+		scale:          scale,
+		waitFor:        waitFor,
+		diagnosticsDir: diagnosticsDir,
+		projectDir:     projectDir,
+		generated:      generated,
+		stdout:         options.Stdout,
+		stderr:         options.Stderr,
+		command:        command,
+	}
+
+	if len(c.services) > 0 || len(c.waitFor) > 0 {
+		if err := c.validateServices(context.Background()); err != nil {
+			return nil, err
+		}
+	}
+
+	return c, nil
 }
 
 /*
@@ -173,9 +295,29 @@ func (c *Compose) Start() error {
 	if c.removeOrphans {
 		args = append(args, "--remove-orphans")
 	}
+	for _, service := range sortedKeys(c.scale) {
+		args = append(args, "--scale", fmt.Sprintf("%s=%d", service, c.scale[service]))
+	}
 	args = append(args, c.services...)
 
-	return c.run(args...)
+	// Generated files are kept from here until Cleanup removes them, and
+	// rewritten if the project is started again
+	if err := c.writeGenerated(); err != nil {
+		return err
+	}
+
+	// Register before bringing the project up so that a partially
+	// started project is still cleaned up
+	Register(c)
+
+	if err := c.run(args...); err != nil {
+		return c.startFailed(err)
+	}
+	if err := c.waitForServices(); err != nil {
+		return c.startFailed(err)
+	}
+
+	return nil
 }
 
 /*
@@ -212,7 +354,15 @@ func (c *Compose) Cleanup() error {
 		args = append(args, "--volumes")
 	}
 
-	return c.run(args...)
+	if err := c.run(args...); err != nil {
+		return err
+	}
+	if err := c.removeGenerated(); err != nil {
+		return err
+	}
+
+	Unregister(c)
+	return nil
 }
 
 /*
@@ -268,29 +418,53 @@ func (c *Compose) GetContainers() ([]ComposeContainer, error) {
 	}
 
 	containers := []ComposeContainer{}
-	if err := json.Unmarshal(out, &containers); err == nil {
-		return containers, nil
-	}
-
-	decoder := json.NewDecoder(bytes.NewReader(out))
-	for {
-		container := ComposeContainer{}
-		if err := decoder.Decode(&container); err != nil {
-			if errors.Is(err, io.EOF) {
-				break
+	if err := json.Unmarshal(out, &containers); err != nil {
+		// Older versions of compose print one object per line
+		containers = []ComposeContainer{}
+		decoder := json.NewDecoder(bytes.NewReader(out))
+		for {
+			container := ComposeContainer{}
+			if err := decoder.Decode(&container); err != nil {
+				if errors.Is(err, io.EOF) {
+					break
+				}
+				return nil, err
 			}
-			return nil, err
+			containers = append(containers, container)
 		}
-		containers = append(containers, container)
+	}
+
+	for i := range containers {
+		containers[i].Index = replicaIndex(containers[i])
 	}
 
 	return containers, nil
 }
 
+var containerNumberLabel = regexp.MustCompile(`(?:^|,)com\.docker\.compose\.container-number=(\d+)`)
+var containerNumberSuffix = regexp.MustCompile(`[-_](\d+)$`)
+
+// replicaIndex finds a compose container's replica number from its
+// labels, or failing that the end of its name, such as "project-web-2".
+func replicaIndex(container ComposeContainer) int {
+	match := containerNumberLabel.FindStringSubmatch(container.Labels)
+	if match == nil {
+		match = containerNumberSuffix.FindStringSubmatch(container.Name)
+	}
+	if match == nil {
+		return 0
+	}
+
+	index, _ := strconv.Atoi(match[1])
+	return index
+}
+
 /*
-GetPort will return the host address for a service's private port.
+GetPort will return the host address for a service's private port. For
+a scaled service the replica index, starting from 1, can be given;
+otherwise the first replica's address is returned.
 */
-func (c *Compose) GetPort(service string, privatePort int, protocol string) (string, error) {
+func (c *Compose) GetPort(service string, privatePort int, protocol string, index ...int) (string, error) {
 	if service == "" {
 		return "", errors.New("service is required")
 	}
@@ -301,7 +475,20 @@ func (c *Compose) GetPort(service string, privatePort int, protocol string) (str
 		protocol = "tcp"
 	}
 
-	out, err := c.output("port", "--protocol", protocol, service, strconv.Itoa(privatePort))
+	if len(index) > 1 {
+		return "", errors.New("only one replica index can be given")
+	}
+
+	args := []string{"port", "--protocol", protocol}
+	if len(index) == 1 {
+		if index[0] <= 0 {
+			return "", errors.New("replica index must be greater than zero")
+		}
+		args = append(args, "--index", strconv.Itoa(index[0]))
+	}
+	args = append(args, service, strconv.Itoa(privatePort))
+
+	out, err := c.output(args...)
 	if err != nil {
 		return "", err
 	}
@@ -309,6 +496,79 @@ func (c *Compose) GetPort(service string, privatePort int, protocol string) (str
 	return strings.TrimSpace(string(out)), nil
 }
 
+/*
+GetPorts will return the host address of a service's private tcp port
+for every running replica of the service, in replica order.
+*/
+func (c *Compose) GetPorts(service string, privatePort int) ([]string, error) {
+	if service == "" {
+		return nil, errors.New("service is required")
+	}
+
+	containers, err := c.GetContainers()
+	if err != nil {
+		return nil, err
+	}
+
+	indexes := []int{}
+	for _, container := range containers {
+		if container.Service == service && strings.ToLower(container.State) == "running" {
+			indexes = append(indexes, container.Index)
+		}
+	}
+	sort.Ints(indexes)
+
+	addresses := []string{}
+	for _, index := range indexes {
+		address, err := c.GetPort(service, privatePort, "tcp", index)
+		if err != nil {
+			return nil, fmt.Errorf("replica %d: %w", index, err)
+		}
+		addresses = append(addresses, address)
+	}
+
+	return addresses, nil
+}
+
+/*
+Scale will change how many replicas of each given service are running,
+starting or removing containers as needed, without touching the other
+services. The new counts are kept for later calls to Start.
+*/
+func (c *Compose) Scale(ctx context.Context, replicas map[string]int) error {
+	if len(replicas) == 0 {
+		return errors.New("at least one service is required")
+	}
+
+	c.lock.Lock()
+	defer c.lock.Unlock()
+
+	args := []string{"up", "--detach", "--no-deps", "--no-recreate"}
+	if c.wait {
+		args = append(args, "--wait")
+		if c.waitTimeout > 0 {
+			args = append(args, "--wait-timeout", strconv.Itoa(int(c.waitTimeout.Seconds())))
+		}
+	}
+	services := sortedKeys(replicas)
+	for _, service := range services {
+		if replicas[service] < 0 {
+			return fmt.Errorf("service %s cannot be scaled to %d replicas", service, replicas[service])
+		}
+		args = append(args, "--scale", fmt.Sprintf("%s=%d", service, replicas[service]))
+	}
+	args = append(args, services...)
+
+	if err := c.runContext(ctx, args...); err != nil {
+		return err
+	}
+
+	for service, count := range replicas {
+		c.scale[service] = count
+	}
+	return nil
+}
+
 /*
 GetLogs will return logs for the compose project. If services are provided,
 only those service logs will be returned.
@@ -325,6 +585,215 @@ func (c *Compose) GetLogs(services ...string) (string, error) {
 	return string(out), nil
 }
 
+/*
+ExportEnv will publish the resolved host address of every published
+port of every running service, named after the service, such as
+WEB_HOST, WEB_PORT_80 and WEB_ADDR_80 for a service named "web".
+*/
+func (c *Compose) ExportEnv() (map[string]string, error) {
+	containers, err := c.GetContainers()
+	if err != nil {
+		return nil, err
+	}
+
+	env := map[string]string{}
+	for _, container := range containers {
+		if strings.ToLower(container.State) != "running" {
+			continue
+		}
+
+		prefix := envName(container.Service)
+		for _, publisher := range container.Publishers {
+			if publisher.PublishedPort == 0 {
+				continue
+			}
+
+			port := fmt.Sprintf("%d/%s", publisher.TargetPort, publisher.Protocol)
+			key := fmt.Sprintf("%s_ADDR_%s", prefix, portEnvName(port))
+			if _, ok := env[key]; ok {
+				continue
+			}
+
+			address, err := c.GetPort(container.Service, publisher.TargetPort, publisher.Protocol)
+			if err != nil {
+				return nil, err
+			}
+			host, hostPort, err := net.SplitHostPort(address)
+			if err != nil {
+				return nil, fmt.Errorf("unexpected address %q for %s port %s: %w", address, container.Service, port, err)
+			}
+			// Ports published on every interface are reachable locally
+			if ip := net.ParseIP(host); ip == nil || ip.IsUnspecified() {
+				host = "127.0.0.1"
+			}
+
+			env[fmt.Sprintf("%s_HOST", prefix)] = host
+			env[fmt.Sprintf("%s_PORT_%s", prefix, portEnvName(port))] = hostPort
+			env[key] = net.JoinHostPort(host, hostPort)
+		}
+	}
+
+	return env, nil
+}
+
+/*
+ComposeExecOptions configure how Compose.Exec runs a command.
+*/
+type ComposeExecOptions struct {
+	// Index is the replica of the service to run the command in,
+	// starting from 1; defaults to the first
+	Index   int
+	User    string
+	WorkDir string
+	Env     map[string]string
+	// Stdin, if set, is passed to the command as its standard input
+	Stdin io.Reader
+}
+
+/*
+Exec will run a command inside a running service's container, like
+`docker compose exec -T`, and return its exit code and output. A
+non-zero exit code is not an error, but a ServiceNotRunningError is
+returned if the service, or the replica asked for, is not running.
+*/
+func (c *Compose) Exec(ctx context.Context, service string, cmd []string, options ComposeExecOptions) (ExecResult, error) {
+	if service == "" {
+		return ExecResult{}, errors.New("service is required")
+	}
+	if len(cmd) == 0 {
+		return ExecResult{}, errors.New("command is required")
+	}
+	if options.Index < 0 {
+		return ExecResult{}, errors.New("replica index cannot be negative")
+	}
+	if err := c.checkRunning(service, options.Index); err != nil {
+		return ExecResult{}, err
+	}
+
+	args := []string{"exec", "-T"}
+	if options.Index > 0 {
+		args = append(args, "--index", strconv.Itoa(options.Index))
+	}
+	if options.User != "" {
+		args = append(args, "--user", options.User)
+	}
+	if options.WorkDir != "" {
+		args = append(args, "--workdir", options.WorkDir)
+	}
+	for _, k := range sortedKeys(options.Env) {
+		args = append(args, "--env", fmt.Sprintf("%s=%s", k, options.Env[k]))
+	}
+	args = append(args, service)
+	args = append(args, cmd...)
+
+	return c.capture(ctx, options.Stdin, false, args...)
+}
+
+/*
+ComposeRunOptions configure how Compose.Run runs a one-off container.
+*/
+type ComposeRunOptions struct {
+	// NoDeps skips starting the service's dependencies
+	NoDeps bool
+	Env    map[string]string
+	// Entrypoint overrides the image's entrypoint
+	Entrypoint string
+	// Volumes are additional "source:target[:ro]" mounts
+	Volumes []string
+	// Ports are additional "[host:]container" ports to publish
+	Ports   []string
+	User    string
+	WorkDir string
+	// Stdin, if set, is passed to the container as its standard input
+	Stdin io.Reader
+}
+
+/*
+Run will run a one-off container for a service, like `docker compose
+run --rm`, such as a migration or seeder in a "tools" profile, and
+return its exit code and output once it exits. The output is also
+streamed to the Stdout and Stderr writers, if set. A non-zero exit code
+is not an error, but a service that is not in the compose files is.
+*/
+func (c *Compose) Run(ctx context.Context, service string, args []string, options ComposeRunOptions) (ExecResult, error) {
+	if service == "" {
+		return ExecResult{}, errors.New("service is required")
+	}
+	known, err := c.knownServices(ctx)
+	if err != nil {
+		return ExecResult{}, err
+	}
+	if !slices.Contains(known, service) {
+		return ExecResult{}, fmt.Errorf("unknown compose service %s; the project has %s", service, strings.Join(known, ", "))
+	}
+
+	runArgs := []string{"run", "--rm", "-T"}
+	if options.NoDeps {
+		runArgs = append(runArgs, "--no-deps")
+	}
+	if options.Entrypoint != "" {
+		runArgs = append(runArgs, "--entrypoint", options.Entrypoint)
+	}
+	for _, k := range sortedKeys(options.Env) {
+		runArgs = append(runArgs, "--env", fmt.Sprintf("%s=%s", k, options.Env[k]))
+	}
+	for _, volume := range options.Volumes {
+		runArgs = append(runArgs, "--volume", volume)
+	}
+	for _, port := range options.Ports {
+		runArgs = append(runArgs, "--publish", port)
+	}
+	if options.User != "" {
+		runArgs = append(runArgs, "--user", options.User)
+	}
+	if options.WorkDir != "" {
+		runArgs = append(runArgs, "--workdir", options.WorkDir)
+	}
+	runArgs = append(runArgs, service)
+	runArgs = append(runArgs, args...)
+
+	return c.capture(ctx, options.Stdin, true, runArgs...)
+}
+
+// capture runs a compose command that runs something inside a
+// container, returning that command's exit code and output rather than
+// failing when it exits non-zero. If stream is set the output is also
+// copied to the configured Stdout and Stderr writers.
+func (c *Compose) capture(ctx context.Context, stdin io.Reader, stream bool, args ...string) (ExecResult, error) {
+	command, _, done, err := c.commandContext(ctx, true, args...)
+	if err != nil {
+		return ExecResult{}, err
+	}
+	defer done()
+
+	stdout := &bytes.Buffer{}
+	stderr := &bytes.Buffer{}
+	command.Stdin = stdin
+	command.Stdout = stdout
+	command.Stderr = stderr
+	if stream && c.stdout != nil {
+		command.Stdout = io.MultiWriter(c.stdout, stdout)
+	}
+	if stream && c.stderr != nil {
+		command.Stderr = io.MultiWriter(c.stderr, stderr)
+	}
+
+	err = command.Run()
+	exitErr := &exec.ExitError{}
+	if err != nil && (!errors.As(err, &exitErr) || ctx.Err() != nil) {
+		return ExecResult{}, fmt.Errorf("docker compose %s failed: %w: %s", args[0], err, strings.TrimSpace(stderr.String()))
+	}
+
+	result := ExecResult{
+		Stdout: stdout.String(),
+		Stderr: stderr.String(),
+	}
+	if err != nil {
+		result.ExitCode = exitErr.ExitCode()
+	}
+	return result, nil
+}
+
 func (c *Compose) GetName() string {
 	return c.name
 }
@@ -336,7 +805,16 @@ func (c *Compose) GetFiles() []string {
 }
 
 func (c *Compose) run(args ...string) error {
-	cmd, stderr := c.commandContext(false, args...)
+	return c.runContext(context.Background(), args...)
+}
+
+func (c *Compose) runContext(ctx context.Context, args ...string) error {
+	cmd, stderr, done, err := c.commandContext(ctx, false, args...)
+	if err != nil {
+		return err
+	}
+	defer done()
+
 	if err := cmd.Run(); err != nil {
 		return fmt.Errorf("docker compose %s failed: %w: %s", strings.Join(args, " "), err, strings.TrimSpace(stderr.String()))
 	}
@@ -344,7 +822,20 @@ func (c *Compose) run(args ...string) error {
 }
 
 func (c *Compose) output(args ...string) ([]byte, error) {
-	cmd, stderr := c.commandContext(true, args...)
+	return c.outputContext(context.Background(), args...)
+}
+
+func (c *Compose) outputContext(ctx context.Context, args ...string) ([]byte, error) {
+	return c.outputWithProfiles(ctx, c.profiles, args...)
+}
+
+func (c *Compose) outputWithProfiles(ctx context.Context, profiles []string, args ...string) ([]byte, error) {
+	cmd, stderr, done, err := c.commandWithProfiles(ctx, true, profiles, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer done()
+
 	out, err := cmd.Output()
 	if err != nil {
 		return nil, fmt.Errorf("docker compose %s failed: %w: %s", strings.Join(args, " "), err, strings.TrimSpace(stderr.String()))
@@ -352,18 +843,36 @@ func (c *Compose) output(args ...string) ([]byte, error) {
 	return out, nil
 }
 
-func (c *Compose) commandContext(captureOutput bool, args ...string) (*exec.Cmd, *bytes.Buffer) {
+func (c *Compose) commandContext(ctx context.Context, captureOutput bool, args ...string) (*exec.Cmd, *bytes.Buffer, func(), error) {
+	return c.commandWithProfiles(ctx, captureOutput, c.profiles, args...)
+}
+
+// commandWithProfiles builds a docker compose command for the project.
+// done must be called once the command has finished, to remove any
+// generated compose files written for it.
+func (c *Compose) commandWithProfiles(ctx context.Context, captureOutput bool, profiles []string, args ...string) (cmd *exec.Cmd, stderr *bytes.Buffer, done func(), err error) {
+	generated, done, err := c.generatedFiles()
+	if err != nil {
+		return nil, nil, nil, err
+	}
+
 	baseArgs := []string{}
 	for _, file := range c.files {
 		baseArgs = append(baseArgs, "--file", file)
 	}
+	for _, file := range generated {
+		baseArgs = append(baseArgs, "--file", file)
+	}
+	if c.projectDir != "" {
+		baseArgs = append(baseArgs, "--project-directory", c.projectDir)
+	}
 	baseArgs = append(baseArgs, "--project-name", c.name)
-	for _, profile := range c.profiles {
+	for _, profile := range profiles {
 		baseArgs = append(baseArgs, "--profile", profile)
 	}
 	baseArgs = append(baseArgs, args...)
 
-	cmd := exec.CommandContext(context.Background(), c.command[0], append(c.command[1:], baseArgs...)...)
+	cmd = exec.CommandContext(ctx, c.command[0], append(c.command[1:], baseArgs...)...)
 	cmd.Dir = c.workDir
 	cmd.Env = os.Environ()
 	for k, v := range c.env {
@@ -373,14 +882,14 @@ func (c *Compose) commandContext(captureOutput bool, args ...string) (*exec.Cmd,
 		cmd.Stdout = c.stdout
 	}
 
-	stderr := &bytes.Buffer{}
+	stderr = &bytes.Buffer{}
 	if c.stderr != nil {
 		cmd.Stderr = io.MultiWriter(c.stderr, stderr)
 	} else {
 		cmd.Stderr = stderr
 	}
 
-	return cmd, stderr
+	return cmd, stderr, done, nil
 }
 
 func detectComposeCommand() ([]string, error) {
@@ -394,5 +903,5 @@ func detectComposeCommand() ([]string, error) {
 }
 
 func generateComposeName() string {
-	return fmt.Sprintf("docker-harness-%d-%d", time.Now().UnixNano(), rand.IntN(1000000))
+	return fmt.Sprintf("%s%d-%d", ComposeProjectPrefix, time.Now().UnixNano(), rand.IntN(1000000))
 }
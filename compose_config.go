@@ -0,0 +1,194 @@
+package dockerharness
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"slices"
+	"strings"
+)
+
+/*
+ComposeProject is the resolved compose project as reported by docker
+compose config, after the files are merged and variables interpolated.
+*/
+type ComposeProject struct {
+	Name     string                    `json:"name"`
+	Services map[string]ComposeService `json:"services"`
+	Networks map[string]ComposeNetwork `json:"networks"`
+	Volumes  map[string]ComposeVolume  `json:"volumes"`
+}
+
+/*
+ComposeService is a single service within a ComposeProject. Environment
+values are nil for variables that are passed through without a value.
+*/
+type ComposeService struct {
+	Name          string                            `json:"-"`
+	Image         string                            `json:"image"`
+	ContainerName string                            `json:"container_name"`
+	Command       []string                          `json:"command"`
+	Entrypoint    []string                          `json:"entrypoint"`
+	Environment   map[string]*string                `json:"environment"`
+	Labels        map[string]string                 `json:"labels"`
+	Ports         []ComposePort                     `json:"ports"`
+	Expose        []string                          `json:"expose"`
+	Healthcheck   *ComposeHealthcheck               `json:"healthcheck"`
+	DependsOn     map[string]ComposeDependency      `json:"depends_on"`
+	Networks      map[string]*ComposeServiceNetwork `json:"networks"`
+	Volumes       []ComposeServiceVolume            `json:"volumes"`
+	Profiles      []string                          `json:"profiles"`
+}
+
+/*
+ComposePort is a port declared by a service. Published is blank when
+docker picks the host port.
+*/
+type ComposePort struct {
+	Mode      string `json:"mode"`
+	HostIP    string `json:"host_ip"`
+	Target    int    `json:"target"`
+	Published string `json:"published"`
+	Protocol  string `json:"protocol"`
+}
+
+/*
+ComposeHealthcheck is a service's healthcheck. Durations are kept as
+compose reports them, such as "10s".
+*/
+type ComposeHealthcheck struct {
+	Test          []string `json:"test"`
+	Interval      string   `json:"interval"`
+	Timeout       string   `json:"timeout"`
+	StartPeriod   string   `json:"start_period"`
+	StartInterval string   `json:"start_interval"`
+	Retries       int      `json:"retries"`
+	Disable       bool     `json:"disable"`
+}
+
+type ComposeDependency struct {
+	Condition string `json:"condition"`
+	Restart   bool   `json:"restart"`
+	Required  *bool  `json:"required"`
+}
+
+type ComposeServiceNetwork struct {
+	Aliases     []string `json:"aliases"`
+	IPv4Address string   `json:"ipv4_address"`
+}
+
+type ComposeServiceVolume struct {
+	Type     string `json:"type"`
+	Source   string `json:"source"`
+	Target   string `json:"target"`
+	ReadOnly bool   `json:"read_only"`
+}
+
+type ComposeNetwork struct {
+	Name     string            `json:"name"`
+	Driver   string            `json:"driver"`
+	External bool              `json:"external"`
+	Internal bool              `json:"internal"`
+	Labels   map[string]string `json:"labels"`
+}
+
+type ComposeVolume struct {
+	Name     string            `json:"name"`
+	Driver   string            `json:"driver"`
+	External bool              `json:"external"`
+	Labels   map[string]string `json:"labels"`
+}
+
+/*
+Config will return the resolved compose project, as docker compose
+config sees it with the harness's files, profiles and environment. It
+does not need the project to be running.
+*/
+func (c *Compose) Config(ctx context.Context) (ComposeProject, error) {
+	out, err := c.outputContext(ctx, "config", "--format", "json")
+	if err != nil {
+		return ComposeProject{}, err
+	}
+
+	return parseComposeConfig(out)
+}
+
+func parseComposeConfig(out []byte) (ComposeProject, error) {
+	project := ComposeProject{}
+	if err := json.Unmarshal(out, &project); err != nil {
+		return ComposeProject{}, fmt.Errorf("failed to parse compose config: %w", err)
+	}
+
+	for name, service := range project.Services {
+		service.Name = name
+		project.Services[name] = service
+	}
+
+	return project, nil
+}
+
+/*
+ServiceNames returns the names of the project's services, sorted.
+*/
+func (p ComposeProject) ServiceNames() []string {
+	return sortedKeys(p.Services)
+}
+
+/*
+TargetPorts returns the container ports a service publishes for the
+protocol, such as to look each up with GetPort without hardcoding them.
+A blank protocol matches tcp.
+*/
+func (s ComposeService) TargetPorts(protocol string) []int {
+	if protocol == "" {
+		protocol = "tcp"
+	}
+
+	ports := []int{}
+	for _, port := range s.Ports {
+		portProtocol := port.Protocol
+		if portProtocol == "" {
+			portProtocol = "tcp"
+		}
+		if strings.EqualFold(portProtocol, protocol) && !slices.Contains(ports, port.Target) {
+			ports = append(ports, port.Target)
+		}
+	}
+	slices.Sort(ports)
+
+	return ports
+}
+
+// validateServices checks that every requested service, and every
+// service with wait strategies, exists in the compose files, in any
+// profile, so that a typo fails up front rather than when the project
+// is started.
+func (c *Compose) validateServices(ctx context.Context) error {
+	known, err := c.knownServices(ctx)
+	if err != nil {
+		return err
+	}
+
+	unknown := []string{}
+	for _, service := range append(slices.Clone(c.services), sortedKeys(c.waitFor)...) {
+		if !slices.Contains(known, service) && !slices.Contains(unknown, service) {
+			unknown = append(unknown, service)
+		}
+	}
+	if len(unknown) > 0 {
+		return fmt.Errorf("unknown compose services %s; the project has %s", strings.Join(unknown, ", "), strings.Join(known, ", "))
+	}
+
+	return nil
+}
+
+// knownServices returns the names of the services in the compose files,
+// in any profile.
+func (c *Compose) knownServices(ctx context.Context) ([]string, error) {
+	out, err := c.outputWithProfiles(ctx, []string{"*"}, "config", "--services")
+	if err != nil {
+		return nil, err
+	}
+
+	return strings.Fields(string(out)), nil
+}
@@ -0,0 +1,103 @@
+package dockerharness
+
+import (
+	"context"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestParseComposeConfig(t *testing.T) {
+	project, err := parseComposeConfig([]byte(`{
+		"name": "example",
+		"services": {
+			"web": {
+				"image": "nginx:alpine",
+				"command": null,
+				"environment": {"APP_ENV": "test", "PASSTHROUGH": null},
+				"healthcheck": {"test": ["CMD", "true"], "interval": "5s", "retries": 3},
+				"depends_on": {"db": {"condition": "service_healthy", "required": true}},
+				"networks": {"app": null},
+				"ports": [
+					{"mode": "ingress", "target": 80, "protocol": "tcp"},
+					{"mode": "ingress", "target": 443, "published": "8443", "protocol": "tcp"},
+					{"mode": "ingress", "target": 53, "protocol": "udp"}
+				],
+				"volumes": [{"type": "volume", "source": "web-cache", "target": "/cache"}]
+			},
+			"db": {"image": "postgres:16", "profiles": ["data"]}
+		},
+		"networks": {"app": {"name": "example_app"}},
+		"volumes": {"web-cache": {"name": "example_web-cache"}}
+	}`))
+	require.Nil(t, err)
+
+	assert.Equal(t, "example", project.Name)
+	assert.Equal(t, []string{"db", "web"}, project.ServiceNames())
+
+	web := project.Services["web"]
+	assert.Equal(t, "web", web.Name)
+	assert.Equal(t, "nginx:alpine", web.Image)
+	assert.Equal(t, "test", *web.Environment["APP_ENV"])
+	assert.Nil(t, web.Environment["PASSTHROUGH"])
+	assert.Equal(t, []string{"CMD", "true"}, web.Healthcheck.Test)
+	assert.Equal(t, "5s", web.Healthcheck.Interval)
+	assert.Equal(t, 3, web.Healthcheck.Retries)
+	assert.Equal(t, "service_healthy", web.DependsOn["db"].Condition)
+	assert.Contains(t, web.Networks, "app")
+	assert.Equal(t, "8443", web.Ports[1].Published)
+	assert.Equal(t, []int{80, 443}, web.TargetPorts(""))
+	assert.Equal(t, []int{53}, web.TargetPorts("udp"))
+	assert.Equal(t, "/cache", web.Volumes[0].Target)
+
+	assert.Equal(t, []string{"data"}, project.Services["db"].Profiles)
+	assert.Equal(t, "example_app", project.Networks["app"].Name)
+	assert.Equal(t, "example_web-cache", project.Volumes["web-cache"].Name)
+
+	_, err = parseComposeConfig([]byte("not json"))
+	require.NotNil(t, err)
+}
+
+func TestComposeConfig(t *testing.T) {
+	requireCompose(t)
+
+	compose, err := NewCompose(composeTestName(t), []string{composeFile("full.yml")})
+	require.Nil(t, err)
+
+	project, err := compose.Config(context.Background())
+	require.Nil(t, err)
+	assert.Equal(t, compose.GetName(), project.Name)
+	assert.Equal(t, []string{"web", "worker"}, project.ServiceNames())
+	assert.Equal(t, "nginx:alpine", project.Services["web"].Image)
+	assert.Equal(t, []int{80}, project.Services["web"].TargetPorts("tcp"))
+	assert.Contains(t, project.Volumes, "web-cache")
+	assert.Contains(t, project.Networks, "app")
+
+	// Services in a profile that is not enabled are left out
+	compose, err = NewCompose(composeTestName(t), []string{composeFile("tools.yml")})
+	require.Nil(t, err)
+	project, err = compose.Config(context.Background())
+	require.Nil(t, err)
+	assert.Equal(t, []string{"app"}, project.ServiceNames())
+}
+
+func TestComposeValidatesServices(t *testing.T) {
+	requireCompose(t)
+
+	_, err := NewComposeWithOptions(ComposeOptions{
+		Name:     composeTestName(t),
+		Files:    []string{composeFile("full.yml")},
+		Services: []string{"web", "wroker"},
+	})
+	require.NotNil(t, err)
+	assert.Contains(t, err.Error(), "wroker")
+
+	// Services behind a profile can still be asked for by name
+	_, err = NewComposeWithOptions(ComposeOptions{
+		Name:     composeTestName(t),
+		Files:    []string{composeFile("tools.yml")},
+		Services: []string{"migrate"},
+	})
+	require.Nil(t, err)
+}
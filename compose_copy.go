@@ -0,0 +1,188 @@
+package dockerharness
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"path"
+	"path/filepath"
+	"strconv"
+	"strings"
+)
+
+/*
+ServiceNotRunningError is returned when a service, or the replica of it
+asked for, has no running container to act on.
+*/
+type ServiceNotRunningError struct {
+	Service string
+	// Index is the replica asked for, or zero for any replica
+	Index int
+}
+
+func (e *ServiceNotRunningError) Error() string {
+	if e.Index > 0 {
+		return fmt.Sprintf("service %s has no running replica %d", e.Service, e.Index)
+	}
+	return fmt.Sprintf("service %s has no running container", e.Service)
+}
+
+/*
+ComposeCopyOptions configure how Compose.CopyToWithOptions and
+Compose.CopyFromWithOptions copy files.
+*/
+type ComposeCopyOptions struct {
+	// Index is the replica to copy to or from, starting from 1. If it is
+	// zero, docker compose copies to every replica, and from the first.
+	Index int
+	// FS, for copying to a service, is the filesystem src is read from,
+	// such as an embed.FS of fixtures, rather than the host
+	FS fs.FS
+}
+
+/*
+CopyTo will copy a file or directory on the host into a running
+service's container, like docker compose cp. A ServiceNotRunningError is
+returned if the service has no running container.
+*/
+func (c *Compose) CopyTo(ctx context.Context, service string, src string, dst string) error {
+	return c.CopyToWithOptions(ctx, service, src, dst, ComposeCopyOptions{})
+}
+
+/*
+CopyToWithOptions will copy a file or directory into a running service's
+container like CopyTo, optionally to a single replica or from an fs.FS.
+*/
+func (c *Compose) CopyToWithOptions(ctx context.Context, service string, src string, dst string, options ComposeCopyOptions) error {
+	if err := c.checkCopy(service, src, dst, options.Index); err != nil {
+		return err
+	}
+
+	if options.FS != nil {
+		dir, err := os.MkdirTemp("", "docker-harness-copy-")
+		if err != nil {
+			return err
+		}
+		defer os.RemoveAll(dir)
+
+		src, err = extractFS(options.FS, src, dir)
+		if err != nil {
+			return err
+		}
+	}
+
+	return c.copy(ctx, options.Index, src, fmt.Sprintf("%s:%s", service, dst))
+}
+
+/*
+CopyFrom will copy a file or directory out of a running service's
+container onto the host, like docker compose cp. A
+ServiceNotRunningError is returned if the service has no running
+container.
+*/
+func (c *Compose) CopyFrom(ctx context.Context, service string, src string, dst string) error {
+	return c.CopyFromWithOptions(ctx, service, src, dst, ComposeCopyOptions{})
+}
+
+/*
+CopyFromWithOptions will copy a file or directory out of a running
+service's container like CopyFrom, optionally from a single replica.
+*/
+func (c *Compose) CopyFromWithOptions(ctx context.Context, service string, src string, dst string, options ComposeCopyOptions) error {
+	if options.FS != nil {
+		return errors.New("an fs.FS can only be copied to a service")
+	}
+	if err := c.checkCopy(service, src, dst, options.Index); err != nil {
+		return err
+	}
+
+	return c.copy(ctx, options.Index, fmt.Sprintf("%s:%s", service, src), dst)
+}
+
+func (c *Compose) copy(ctx context.Context, index int, src string, dst string) error {
+	args := []string{"cp"}
+	if index > 0 {
+		args = append(args, "--index", strconv.Itoa(index))
+	}
+	args = append(args, src, dst)
+
+	return c.runContext(ctx, args...)
+}
+
+// checkCopy validates a copy's arguments, and that the service has a
+// running container to copy to or from.
+func (c *Compose) checkCopy(service string, src string, dst string, index int) error {
+	if service == "" {
+		return errors.New("service is required")
+	}
+	if src == "" || dst == "" {
+		return errors.New("source and destination paths are required")
+	}
+	if index < 0 {
+		return errors.New("replica index cannot be negative")
+	}
+
+	return c.checkRunning(service, index)
+}
+
+// checkRunning returns a ServiceNotRunningError unless the service, or
+// the replica of it if index is set, has a running container.
+func (c *Compose) checkRunning(service string, index int) error {
+	containers, err := c.GetContainers()
+	if err != nil {
+		return err
+	}
+	for _, container := range containers {
+		if container.Service != service || strings.ToLower(container.State) != "running" {
+			continue
+		}
+		if index == 0 || container.Index == index {
+			return nil
+		}
+	}
+
+	return &ServiceNotRunningError{Service: service, Index: index}
+}
+
+// extractFS writes a file or directory from fsys into dir under the same
+// base name, so that copying it behaves as copying it from the host
+// would, and returns the path written.
+func extractFS(fsys fs.FS, src string, dir string) (string, error) {
+	src = path.Clean(strings.TrimPrefix(src, "/"))
+	info, err := fs.Stat(fsys, src)
+	if err != nil {
+		return "", err
+	}
+
+	target := filepath.Join(dir, path.Base(src))
+	if src == "." {
+		target = filepath.Join(dir, "root")
+	}
+
+	if info.IsDir() {
+		sub, err := fs.Sub(fsys, src)
+		if err != nil {
+			return "", err
+		}
+		if err := os.CopyFS(target, sub); err != nil {
+			return "", fmt.Errorf("failed to read %s: %w", src, err)
+		}
+		// The root of the filesystem has no name of its own, so its
+		// contents are copied, as with "dir/." on the host
+		if src == "." {
+			return target + string(filepath.Separator) + ".", nil
+		}
+		return target, nil
+	}
+
+	content, err := fs.ReadFile(fsys, src)
+	if err != nil {
+		return "", err
+	}
+	if err := os.WriteFile(target, content, 0o644); err != nil {
+		return "", err
+	}
+	return target, nil
+}
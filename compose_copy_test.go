@@ -0,0 +1,95 @@
+package dockerharness
+
+import (
+	"context"
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+	"testing/fstest"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestServiceNotRunningError(t *testing.T) {
+	assert.Equal(t, "service web has no running container", (&ServiceNotRunningError{Service: "web"}).Error())
+	assert.Equal(t, "service web has no running replica 2", (&ServiceNotRunningError{Service: "web", Index: 2}).Error())
+}
+
+func TestExtractFS(t *testing.T) {
+	fsys := fstest.MapFS{
+		"fixtures/users.json":        {Data: []byte(`[]`)},
+		"fixtures/orders/first.json": {Data: []byte(`{}`)},
+	}
+
+	dir := t.TempDir()
+	file, err := extractFS(fsys, "/fixtures/users.json", dir)
+	require.Nil(t, err)
+	assert.Equal(t, filepath.Join(dir, "users.json"), file)
+	contents, err := os.ReadFile(file)
+	require.Nil(t, err)
+	assert.Equal(t, "[]", string(contents))
+
+	dir = t.TempDir()
+	tree, err := extractFS(fsys, "fixtures", dir)
+	require.Nil(t, err)
+	assert.Equal(t, filepath.Join(dir, "fixtures"), tree)
+	assert.FileExists(t, filepath.Join(tree, "orders", "first.json"))
+
+	dir = t.TempDir()
+	root, err := extractFS(fsys, ".", dir)
+	require.Nil(t, err)
+	assert.Equal(t, filepath.Join(dir, "root")+string(filepath.Separator)+".", root)
+	assert.FileExists(t, filepath.Join(dir, "root", "fixtures", "users.json"))
+
+	_, err = extractFS(fsys, "missing", t.TempDir())
+	require.NotNil(t, err)
+}
+
+func TestComposeCopy(t *testing.T) {
+	requireCompose(t)
+
+	compose, err := NewCompose(composeTestName(t), []string{composeFile("full.yml")})
+	require.Nil(t, err)
+	defer compose.Cleanup()
+	require.Nil(t, compose.Start())
+	ctx := context.Background()
+
+	// A fixture from the host
+	fixture := filepath.Join(t.TempDir(), "fixture.txt")
+	require.Nil(t, os.WriteFile(fixture, []byte("from the host"), 0o644))
+	require.Nil(t, compose.CopyTo(ctx, "worker", fixture, "/data/fixture.txt"))
+
+	result, err := compose.Exec(ctx, "worker", []string{"cat", "/data/fixture.txt"}, ComposeExecOptions{})
+	require.Nil(t, err)
+	assert.Equal(t, "from the host", result.Stdout)
+
+	// A directory from an fs.FS, to a single replica
+	fsys := fstest.MapFS{"seed/data.csv": {Data: []byte("a,b\n")}}
+	require.Nil(t, compose.CopyToWithOptions(ctx, "worker", "seed", "/data/seed", ComposeCopyOptions{Index: 1, FS: fsys}))
+
+	result, err = compose.Exec(ctx, "worker", []string{"cat", "/data/seed/data.csv"}, ComposeExecOptions{})
+	require.Nil(t, err)
+	assert.Equal(t, "a,b\n", result.Stdout)
+
+	// A report generated inside the service
+	_, err = compose.Exec(ctx, "worker", []string{"sh", "-c", "echo passed > /data/report.txt"}, ComposeExecOptions{})
+	require.Nil(t, err)
+	report := filepath.Join(t.TempDir(), "report.txt")
+	require.Nil(t, compose.CopyFrom(ctx, "worker", "/data/report.txt", report))
+	contents, err := os.ReadFile(report)
+	require.Nil(t, err)
+	assert.Equal(t, "passed\n", string(contents))
+
+	// Services without a running container fail with a typed error
+	require.Nil(t, compose.StopService("worker", 1))
+	err = compose.CopyFrom(ctx, "worker", "/data/report.txt", report)
+	notRunning := &ServiceNotRunningError{}
+	require.True(t, errors.As(err, &notRunning))
+	assert.Equal(t, "worker", notRunning.Service)
+
+	err = compose.CopyToWithOptions(ctx, "web", fixture, "/tmp/fixture.txt", ComposeCopyOptions{Index: 2})
+	require.True(t, errors.As(err, &notRunning))
+	assert.Equal(t, 2, notRunning.Index)
+}
@@ -0,0 +1,193 @@
+package dockerharness
+
+import (
+	"archive/tar"
+	"compress/gzip"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	docker "github.com/docker/docker/client"
+)
+
+/*
+CollectDiagnostics will gather everything needed to debug a failed test
+against the project into one place: the compose CLI version, the
+resolved config, `ps --all` JSON, docker inspect output for every
+container, and each service's logs. If path ends in .tar.gz or .tgz they
+are written into that archive; otherwise into that directory, which is
+created if needed and should not hold earlier diagnostics. Collection
+is best effort - whatever can be gathered is written, and anything that
+could not be is reported in errors.txt and in the returned error.
+*/
+func (c *Compose) CollectDiagnostics(ctx context.Context, path string) error {
+	if path == "" {
+		return errors.New("diagnostics path is required")
+	}
+
+	files, collectErr := c.diagnostics(ctx)
+	if collectErr != nil {
+		files["errors.txt"] = []byte(collectErr.Error() + "\n")
+	}
+
+	var err error
+	if strings.HasSuffix(path, ".tar.gz") || strings.HasSuffix(path, ".tgz") {
+		err = writeDiagnosticsArchive(path, files)
+	} else {
+		err = writeDiagnosticsDir(path, files)
+	}
+	if err != nil {
+		return fmt.Errorf("failed to write diagnostics: %w", err)
+	}
+
+	return collectErr
+}
+
+// diagnostics gathers the diagnostic files by name, continuing past
+// anything that fails.
+func (c *Compose) diagnostics(ctx context.Context) (map[string][]byte, error) {
+	files := map[string][]byte{}
+	errs := []error{}
+
+	collect := func(name string, args ...string) {
+		out, err := c.outputContext(ctx, args...)
+		if err != nil {
+			errs = append(errs, err)
+			return
+		}
+		files[name] = out
+	}
+
+	collect("version.txt", "version")
+	collect("config.yml", "config")
+	collect("ps.json", "ps", "--all", "--format", "json")
+
+	out, err := c.outputContext(ctx, "ps", "--all", "--services")
+	if err != nil {
+		errs = append(errs, err)
+	}
+	for _, service := range strings.Fields(string(out)) {
+		collect(filepath.Join("logs", service+".log"), "logs", "--no-color", "--timestamps", service)
+	}
+
+	inspect, err := c.inspectContainers(ctx)
+	if err != nil {
+		errs = append(errs, err)
+	} else {
+		files["inspect.json"] = inspect
+	}
+
+	return files, errors.Join(errs...)
+}
+
+// inspectContainers returns docker inspect output for every container
+// in the project, as a JSON array like `docker inspect` prints.
+func (c *Compose) inspectContainers(ctx context.Context) ([]byte, error) {
+	containers, err := c.GetContainers()
+	if err != nil {
+		return nil, err
+	}
+
+	client, err := docker.NewClientWithOpts(docker.FromEnv, docker.WithAPIVersionNegotiation())
+	if err != nil {
+		return nil, err
+	}
+	defer client.Close()
+
+	inspected := []json.RawMessage{}
+	errs := []error{}
+	for _, container := range containers {
+		_, raw, err := client.ContainerInspectWithRaw(ctx, container.ID, false)
+		if err != nil {
+			errs = append(errs, fmt.Errorf("failed to inspect %s: %w", container.Name, err))
+			continue
+		}
+		inspected = append(inspected, raw)
+	}
+
+	out, err := json.MarshalIndent(inspected, "", "  ")
+	if err != nil {
+		return nil, err
+	}
+	return out, errors.Join(errs...)
+}
+
+func writeDiagnosticsDir(dir string, files map[string][]byte) error {
+	for _, name := range sortedKeys(files) {
+		file := filepath.Join(dir, name)
+		if err := os.MkdirAll(filepath.Dir(file), 0o755); err != nil {
+			return err
+		}
+		if err := os.WriteFile(file, files[name], 0o644); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func writeDiagnosticsArchive(path string, files map[string][]byte) error {
+	if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+		return err
+	}
+	out, err := os.Create(path)
+	if err != nil {
+		return err
+	}
+	defer out.Close()
+
+	gz := gzip.NewWriter(out)
+	archive := tar.NewWriter(gz)
+	now := time.Now()
+	for _, name := range sortedKeys(files) {
+		header := &tar.Header{
+			Name:    filepath.ToSlash(name),
+			Mode:    0o644,
+			Size:    int64(len(files[name])),
+			ModTime: now,
+		}
+		if err := archive.WriteHeader(header); err != nil {
+			return err
+		}
+		if _, err := archive.Write(files[name]); err != nil {
+			return err
+		}
+	}
+
+	if err := archive.Close(); err != nil {
+		return err
+	}
+	if err := gz.Close(); err != nil {
+		return err
+	}
+	return out.Close()
+}
+
+// startFailed collects diagnostics for a failed Start into the
+// diagnostics directory, if one is set, noting where on the error. Each
+// failure gets a directory of its own, so that a project started again
+// under the same name never mixes its diagnostics with older ones.
+func (c *Compose) startFailed(err error) error {
+	if c.diagnosticsDir == "" {
+		return err
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), defaultWaitTimeout)
+	defer cancel()
+
+	path := filepath.Join(c.diagnosticsDir, diagnosticsDirName(c.name, time.Now()))
+	if diagErr := c.CollectDiagnostics(ctx, path); diagErr != nil {
+		return fmt.Errorf("%w\ndiagnostics written to %s, with errors: %v", err, path, diagErr)
+	}
+	return fmt.Errorf("%w\ndiagnostics written to %s", err, path)
+}
+
+// diagnosticsDirName names the directory a failed Start's diagnostics
+// are written into, after the project and the time of the failure.
+func diagnosticsDirName(project string, at time.Time) string {
+	return fmt.Sprintf("%s-%s", project, at.UTC().Format("20060102T150405.000000000Z"))
+}
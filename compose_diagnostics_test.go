@@ -0,0 +1,128 @@
+package dockerharness
+
+import (
+	"archive/tar"
+	"compress/gzip"
+	"context"
+	"encoding/json"
+	"io"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestWriteDiagnostics(t *testing.T) {
+	files := map[string][]byte{
+		"version.txt":         []byte("v2\n"),
+		"logs/web.log":        []byte("started\n"),
+		"logs/worker.log":     []byte("worker-ready\n"),
+		"nested/deeper/a.txt": []byte("a"),
+	}
+
+	dir := filepath.Join(t.TempDir(), "diagnostics")
+	require.Nil(t, writeDiagnosticsDir(dir, files))
+	for name, expected := range files {
+		contents, err := os.ReadFile(filepath.Join(dir, name))
+		require.Nil(t, err)
+		assert.Equal(t, string(expected), string(contents))
+	}
+
+	archive := filepath.Join(t.TempDir(), "out", "diagnostics.tar.gz")
+	require.Nil(t, writeDiagnosticsArchive(archive, files))
+	assert.Equal(t, files, readDiagnosticsArchive(t, archive))
+}
+
+func TestComposeCollectDiagnostics(t *testing.T) {
+	requireCompose(t)
+
+	compose, err := NewCompose(composeTestName(t), []string{composeFile("full.yml")})
+	require.Nil(t, err)
+	defer compose.Cleanup()
+	require.Nil(t, compose.Start())
+
+	dir := filepath.Join(t.TempDir(), "diagnostics")
+	require.Nil(t, compose.CollectDiagnostics(context.Background(), dir))
+
+	for _, name := range []string{"version.txt", "config.yml", "ps.json", "logs/web.log", "logs/worker.log"} {
+		assert.FileExists(t, filepath.Join(dir, name))
+	}
+	logs, err := os.ReadFile(filepath.Join(dir, "logs", "worker.log"))
+	require.Nil(t, err)
+	assert.Contains(t, string(logs), "worker-ready")
+
+	inspect, err := os.ReadFile(filepath.Join(dir, "inspect.json"))
+	require.Nil(t, err)
+	inspected := []map[string]any{}
+	require.Nil(t, json.Unmarshal(inspect, &inspected))
+	assert.Len(t, inspected, 2)
+
+	archive := filepath.Join(t.TempDir(), "diagnostics.tgz")
+	require.Nil(t, compose.CollectDiagnostics(context.Background(), archive))
+	assert.Contains(t, readDiagnosticsArchive(t, archive), "logs/worker.log")
+}
+
+func TestComposeDiagnosticsOnFailedStart(t *testing.T) {
+	requireCompose(t)
+
+	dir := t.TempDir()
+	compose, err := NewComposeWithOptions(ComposeOptions{
+		Name:           composeTestName(t),
+		Files:          []string{composeFile("full.yml")},
+		NoWait:         true,
+		WaitTimeout:    2 * time.Second,
+		WaitFor:        map[string][]WaitStrategy{"worker": {WaitForLog("never logged")}},
+		DiagnosticsDir: dir,
+	})
+	require.Nil(t, err)
+	defer compose.Cleanup()
+
+	err = compose.Start()
+	require.NotNil(t, err)
+	entries, err := os.ReadDir(dir)
+	require.Nil(t, err)
+	require.Len(t, entries, 1)
+	path := filepath.Join(dir, entries[0].Name())
+	assert.Contains(t, path, compose.GetName())
+	assert.FileExists(t, filepath.Join(path, "logs", "worker.log"))
+
+	// A second failure is kept apart from the first
+	err = compose.Start()
+	require.NotNil(t, err)
+	entries, err = os.ReadDir(dir)
+	require.Nil(t, err)
+	assert.Len(t, entries, 2)
+}
+
+func TestDiagnosticsDirName(t *testing.T) {
+	at := time.Date(2024, 5, 1, 10, 30, 0, 123456789, time.UTC)
+	assert.Equal(t, "project-20240501T103000.123456789Z", diagnosticsDirName("project", at))
+	assert.NotEqual(t, diagnosticsDirName("project", at), diagnosticsDirName("project", at.Add(time.Nanosecond)))
+}
+
+func readDiagnosticsArchive(t *testing.T, path string) map[string][]byte {
+	t.Helper()
+
+	file, err := os.Open(path)
+	require.Nil(t, err)
+	defer file.Close()
+	gz, err := gzip.NewReader(file)
+	require.Nil(t, err)
+
+	files := map[string][]byte{}
+	archive := tar.NewReader(gz)
+	for {
+		header, err := archive.Next()
+		if err == io.EOF {
+			break
+		}
+		require.Nil(t, err)
+		contents, err := io.ReadAll(archive)
+		require.Nil(t, err)
+		files[header.Name] = contents
+	}
+	return files
+}
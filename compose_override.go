@@ -0,0 +1,118 @@
+package dockerharness
+
+import (
+	"errors"
+	"fmt"
+	"slices"
+
+	"gopkg.in/yaml.v3"
+)
+
+/*
+ComposeOverride builds a compose override file in Go, for tweaking a
+shared compose file per test without writing YAML by hand. Set it as
+ComposeOptions.Override; the harness writes it to a temporary file that
+is applied after the compose files and removed on Cleanup. Each method
+returns the override so that calls can be chained:
+
+	override := NewComposeOverride().
+		Image("api", "example/api:pr-123").
+		Env("api", map[string]string{"LOG_LEVEL": "debug"})
+
+Overrides follow compose's merge rules: single values such as image and
+command replace the original, environment entries are merged by name,
+and ports are added to the service's existing ports.
+*/
+type ComposeOverride struct {
+	services map[string]map[string]any
+}
+
+func NewComposeOverride() *ComposeOverride {
+	return &ComposeOverride{services: map[string]map[string]any{}}
+}
+
+/*
+Image will replace the image a service runs, such as to pin a tag.
+*/
+func (o *ComposeOverride) Image(service string, image string) *ComposeOverride {
+	return o.Set(service, "image", image)
+}
+
+/*
+Env will add or replace environment variables on a service.
+*/
+func (o *ComposeOverride) Env(service string, env map[string]string) *ComposeOverride {
+	environment, ok := o.service(service)["environment"].(map[string]string)
+	if !ok {
+		environment = map[string]string{}
+	}
+	for key, value := range env {
+		environment[key] = value
+	}
+
+	return o.Set(service, "environment", environment)
+}
+
+/*
+Ports will publish additional ports for a service, in compose's short
+syntax such as "8080:80" or "9090".
+*/
+func (o *ComposeOverride) Ports(service string, ports ...string) *ComposeOverride {
+	existing, _ := o.service(service)["ports"].([]string)
+	return o.Set(service, "ports", append(slices.Clone(existing), ports...))
+}
+
+/*
+Command will replace the command a service runs.
+*/
+func (o *ComposeOverride) Command(service string, cmd ...string) *ComposeOverride {
+	return o.Set(service, "command", slices.Clone(cmd))
+}
+
+/*
+Entrypoint will replace the entrypoint of a service.
+*/
+func (o *ComposeOverride) Entrypoint(service string, entrypoint ...string) *ComposeOverride {
+	return o.Set(service, "entrypoint", slices.Clone(entrypoint))
+}
+
+/*
+Set will set any other key of a service to a value, which is written as
+YAML, for tweaks without a method of their own:
+
+	override.Set("db", "shm_size", "256m")
+*/
+func (o *ComposeOverride) Set(service string, key string, value any) *ComposeOverride {
+	o.service(service)[key] = value
+	return o
+}
+
+func (o *ComposeOverride) service(name string) map[string]any {
+	if o.services == nil {
+		o.services = map[string]map[string]any{}
+	}
+	if o.services[name] == nil {
+		o.services[name] = map[string]any{}
+	}
+	return o.services[name]
+}
+
+// render produces the override file's contents.
+func (o *ComposeOverride) render() ([]byte, error) {
+	for name, service := range o.services {
+		if name == "" {
+			return nil, errors.New("override service name cannot be blank")
+		}
+		for key := range service {
+			if key == "" {
+				return nil, fmt.Errorf("override for service %s has a blank key", name)
+			}
+		}
+	}
+
+	out, err := yaml.Marshal(map[string]any{"services": o.services})
+	if err != nil {
+		return nil, fmt.Errorf("failed to render compose override: %w", err)
+	}
+	return out, nil
+}
@@ -0,0 +1,74 @@
+package dockerharness
+
+import (
+	"context"
+	"os"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestComposeOverrideRender(t *testing.T) {
+	override := NewComposeOverride().
+		Image("web", "nginx:1.27-alpine").
+		Env("web", map[string]string{"A": "1"}).
+		Env("web", map[string]string{"B": "2"}).
+		Ports("web", "8080").
+		Ports("web", "9090:90").
+		Command("worker", "sleep", "60").
+		Set("worker", "shm_size", "64m")
+
+	out, err := override.render()
+	require.Nil(t, err)
+	assert.Equal(t, `services:
+    web:
+        environment:
+            A: "1"
+            B: "2"
+        image: nginx:1.27-alpine
+        ports:
+            - "8080"
+            - 9090:90
+    worker:
+        command:
+            - sleep
+            - "60"
+        shm_size: 64m
+`, string(out))
+
+	_, err = NewComposeOverride().Image("", "nginx").render()
+	require.NotNil(t, err)
+}
+
+func TestComposeWithOverride(t *testing.T) {
+	requireCompose(t)
+
+	compose, err := NewComposeWithOptions(ComposeOptions{
+		Name:  composeTestName(t),
+		Files: []string{composeFile("full.yml")},
+		Override: NewComposeOverride().
+			Image("worker", "busybox:1.37").
+			Env("worker", map[string]string{"HARNESS_VALUE": "overridden"}).
+			Command("worker", "sh", "-c", "echo $$HARNESS_VALUE && sleep 300"),
+	})
+	require.Nil(t, err)
+	defer compose.Cleanup()
+
+	project, err := compose.Config(context.Background())
+	require.Nil(t, err)
+	worker := project.Services["worker"]
+	assert.Equal(t, "busybox:1.37", worker.Image)
+	assert.Equal(t, "overridden", *worker.Environment["HARNESS_VALUE"])
+	assert.Equal(t, "nginx:alpine", project.Services["web"].Image)
+
+	require.Nil(t, compose.Start())
+	result, err := compose.Exec(context.Background(), "worker", []string{"sh", "-c", "echo $HARNESS_VALUE"}, ComposeExecOptions{})
+	require.Nil(t, err)
+	assert.Equal(t, "overridden\n", result.Stdout)
+
+	dir := compose.tempDir
+	require.Nil(t, compose.Cleanup())
+	_, err = os.Stat(dir)
+	assert.True(t, os.IsNotExist(err))
+}
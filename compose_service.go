@@ -0,0 +1,237 @@
+package dockerharness
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const composeServiceVerifyTimeout = 10 * time.Second
+
+// Service states checked after a per-service lifecycle change
+const (
+	serviceRunning = "running"
+	serviceStopped = "stopped"
+	servicePaused  = "paused"
+	serviceRemoved = "removed"
+)
+
+/*
+StartService will start a single service, creating its containers if
+needed, without starting the services it depends on or touching the
+rest of the project. It waits for the service the same way Start does,
+including any WaitFor strategies the service has.
+*/
+func (c *Compose) StartService(service string) error {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+
+	args := []string{"up", "--detach", "--no-deps"}
+	if c.wait {
+		args = append(args, "--wait")
+		if c.waitTimeout > 0 {
+			args = append(args, "--wait-timeout", strconv.Itoa(int(c.waitTimeout.Seconds())))
+		}
+	}
+	// Keep the service at the replica count it was scaled to rather than
+	// the compose file's
+	if replicas, ok := c.scale[service]; ok {
+		args = append(args, "--scale", fmt.Sprintf("%s=%d", service, replicas))
+	}
+	args = append(args, service)
+
+	if err := c.serviceCommand(service, serviceRunning, args...); err != nil {
+		return err
+	}
+	return c.waitForStartedService(service)
+}
+
+/*
+StopService will stop a single service's containers, waiting up to wait
+seconds for them to stop before they are killed, while the rest of the
+project keeps running.
+*/
+func (c *Compose) StopService(service string, wait int) error {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+
+	args := []string{"stop"}
+	if wait > 0 {
+		args = append(args, "--timeout", strconv.Itoa(wait))
+	}
+	args = append(args, service)
+
+	return c.serviceCommand(service, serviceStopped, args...)
+}
+
+/*
+RestartService will restart a single service's containers, waiting up
+to wait seconds for them to stop before they are killed, and then for
+any WaitFor strategies the service has.
+*/
+func (c *Compose) RestartService(service string, wait int) error {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+
+	args := []string{"restart"}
+	if wait > 0 {
+		args = append(args, "--timeout", strconv.Itoa(wait))
+	}
+	args = append(args, service)
+
+	if err := c.serviceCommand(service, serviceRunning, args...); err != nil {
+		return err
+	}
+	return c.waitForStartedService(service)
+}
+
+/*
+KillService will send a signal to a single service's containers; a
+blank signal sends SIGKILL. The service is only checked to have stopped
+for SIGKILL, as other signals may be handled without exiting.
+*/
+func (c *Compose) KillService(service string, signal string) error {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+
+	if signal == "" {
+		signal = "SIGKILL"
+	}
+
+	expected := ""
+	if strings.TrimPrefix(strings.ToUpper(signal), "SIG") == "KILL" || signal == "9" {
+		expected = serviceStopped
+	}
+
+	return c.serviceCommand(service, expected, "kill", "--signal", signal, service)
+}
+
+/*
+PauseService will pause a single service's containers, freezing their
+processes without stopping them, such as to simulate a hung dependency.
+*/
+func (c *Compose) PauseService(service string) error {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+
+	return c.serviceCommand(service, servicePaused, "pause", service)
+}
+
+/*
+UnpauseService will resume a single service's paused containers.
+*/
+func (c *Compose) UnpauseService(service string) error {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+
+	return c.serviceCommand(service, serviceRunning, "unpause", service)
+}
+
+/*
+RemoveService will stop and remove a single service's containers, and
+their anonymous volumes if volumes is set. StartService recreates them.
+*/
+func (c *Compose) RemoveService(service string, volumes bool) error {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+
+	args := []string{"rm", "--stop", "--force"}
+	if volumes {
+		args = append(args, "--volumes")
+	}
+	args = append(args, service)
+
+	return c.serviceCommand(service, serviceRemoved, args...)
+}
+
+// waitForStartedService checks the WaitFor strategies of a service that
+// has just been started again, within the wait timeout.
+func (c *Compose) waitForStartedService(service string) error {
+	if len(c.waitFor[service]) == 0 {
+		return nil
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), c.waitTimeout)
+	defer cancel()
+
+	return c.waitForService(ctx, service)
+}
+
+// serviceCommand runs a compose command against a service and then
+// checks, through GetContainers, that the service reached the expected
+// state. A blank state skips the check.
+func (c *Compose) serviceCommand(service string, expected string, args ...string) error {
+	if service == "" {
+		return errors.New("service is required")
+	}
+
+	if err := c.run(args...); err != nil {
+		return err
+	}
+	if expected == "" {
+		return nil
+	}
+
+	// Docker can take a moment to report the new state, such as a
+	// killed container exiting
+	ctx, cancel := context.WithTimeout(context.Background(), composeServiceVerifyTimeout)
+	defer cancel()
+
+	return poll(ctx, func() error {
+		containers, err := c.GetContainers()
+		if err != nil {
+			return err
+		}
+
+		states := []string{}
+		for _, container := range containers {
+			if container.Service == service {
+				states = append(states, strings.ToLower(container.State))
+			}
+		}
+
+		if !serviceInState(states, expected) {
+			return fmt.Errorf("service %s is %s, expected it to be %s", service, describeServiceStates(states), expected)
+		}
+		return nil
+	})
+}
+
+// serviceInState reports whether every container of a service, given
+// their states, is in the expected service state.
+func serviceInState(states []string, expected string) bool {
+	if expected == serviceRemoved {
+		return len(states) == 0
+	}
+	if len(states) == 0 {
+		return false
+	}
+
+	for _, state := range states {
+		switch expected {
+		case serviceRunning, servicePaused:
+			if state != expected {
+				return false
+			}
+		case serviceStopped:
+			if state != "exited" && state != "dead" && state != "created" {
+				return false
+			}
+		}
+	}
+	return true
+}
+
+func describeServiceStates(states []string) string {
+	if len(states) == 0 {
+		return "not created"
+	}
+
+	states = slices.Clone(states)
+	slices.Sort(states)
+	return strings.Join(slices.Compact(states), "/")
+}
@@ -0,0 +1,132 @@
+package dockerharness
+
+import (
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+)
+
+/*
+ComposeSource is compose file content given without a file on disk, such
+as an embedded or generated definition. Create one with ComposeBytes,
+ComposeString or ComposeFS and pass it in ComposeOptions.Sources.
+*/
+type ComposeSource struct {
+	name        string
+	readContent func() ([]byte, error)
+}
+
+/*
+ComposeBytes will use the bytes as the content of a compose file.
+*/
+func ComposeBytes(content []byte) ComposeSource {
+	content = append([]byte{}, content...)
+	return ComposeSource{
+		name:        "inline compose content",
+		readContent: func() ([]byte, error) { return content, nil },
+	}
+}
+
+/*
+ComposeString will use the string as the content of a compose file.
+*/
+func ComposeString(content string) ComposeSource {
+	return ComposeBytes([]byte(content))
+}
+
+/*
+ComposeFS will use a compose file read from a filesystem, such as an
+embed.FS holding the test's stack definition.
+*/
+func ComposeFS(fsys fs.FS, path string) ComposeSource {
+	return ComposeSource{
+		name: path,
+		readContent: func() ([]byte, error) {
+			if fsys == nil {
+				return nil, errors.New("compose filesystem cannot be nil")
+			}
+			return fs.ReadFile(fsys, path)
+		},
+	}
+}
+
+func (s ComposeSource) read() ([]byte, error) {
+	if s.readContent == nil {
+		return nil, errors.New("compose source is empty; create it with ComposeBytes, ComposeString or ComposeFS")
+	}
+
+	content, err := s.readContent()
+	if err != nil {
+		return nil, fmt.Errorf("failed to read %s: %w", s.name, err)
+	}
+	return content, nil
+}
+
+// writeGenerated writes the compose sources and override into a private
+// temporary directory for Start, if they have not been already, such as
+// after Cleanup removed them.
+func (c *Compose) writeGenerated() error {
+	if len(c.generated) == 0 || c.tempDir != "" {
+		return nil
+	}
+
+	dir, files, err := writeComposeFiles(c.generated)
+	if err != nil {
+		return err
+	}
+	c.tempDir = dir
+	c.tempFiles = files
+
+	return nil
+}
+
+// generatedFiles returns the generated compose files for a single
+// command. Until Start writes them, they are written for just that
+// command, and removed by calling done once it has finished.
+func (c *Compose) generatedFiles() (files []string, done func(), err error) {
+	if len(c.generated) == 0 || c.tempDir != "" {
+		return c.tempFiles, func() {}, nil
+	}
+
+	dir, files, err := writeComposeFiles(c.generated)
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to write generated compose files: %w", err)
+	}
+	return files, func() { os.RemoveAll(dir) }, nil
+}
+
+func writeComposeFiles(contents [][]byte) (string, []string, error) {
+	dir, err := os.MkdirTemp("", "docker-harness-compose-")
+	if err != nil {
+		return "", nil, err
+	}
+
+	files := []string{}
+	for i, content := range contents {
+		file := filepath.Join(dir, fmt.Sprintf("compose-%d.yml", i))
+		if err := os.WriteFile(file, content, 0o600); err != nil {
+			os.RemoveAll(dir)
+			return "", nil, err
+		}
+		files = append(files, file)
+	}
+
+	return dir, files, nil
+}
+
+// removeGenerated deletes the temporary directory, if written.
+func (c *Compose) removeGenerated() error {
+	if c.tempDir == "" {
+		return nil
+	}
+
+	if err := os.RemoveAll(c.tempDir); err != nil {
+		return fmt.Errorf("failed to remove generated compose files: %w", err)
+	}
+	c.tempDir = ""
+	c.tempFiles = nil
+
+	return nil
+}
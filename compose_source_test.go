@@ -0,0 +1,141 @@
+package dockerharness
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"testing"
+	"testing/fstest"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestComposeSources(t *testing.T) {
+	content, err := ComposeString("services: {}\n").read()
+	require.Nil(t, err)
+	assert.Equal(t, "services: {}\n", string(content))
+
+	// The bytes are copied, so later changes by the caller do not leak in
+	data := []byte("services: {}\n")
+	source := ComposeBytes(data)
+	data[0] = 'X'
+	content, err = source.read()
+	require.Nil(t, err)
+	assert.Equal(t, "services: {}\n", string(content))
+
+	fsys := fstest.MapFS{"stacks/app.yml": {Data: []byte("services:\n  app: {}\n")}}
+	content, err = ComposeFS(fsys, "stacks/app.yml").read()
+	require.Nil(t, err)
+	assert.Equal(t, "services:\n  app: {}\n", string(content))
+
+	_, err = ComposeFS(fsys, "missing.yml").read()
+	require.NotNil(t, err)
+	assert.Contains(t, err.Error(), "missing.yml")
+	_, err = ComposeFS(nil, "app.yml").read()
+	require.NotNil(t, err)
+	_, err = ComposeSource{}.read()
+	require.NotNil(t, err)
+}
+
+func TestComposeGeneratedFiles(t *testing.T) {
+	c := &Compose{generated: [][]byte{[]byte("first\n"), []byte("second\n")}}
+
+	require.Nil(t, c.writeGenerated())
+	dir := c.tempDir
+	require.Len(t, c.tempFiles, 2)
+	for i, expected := range []string{"first\n", "second\n"} {
+		assert.Equal(t, dir, filepath.Dir(c.tempFiles[i]))
+		contents, err := os.ReadFile(c.tempFiles[i])
+		require.Nil(t, err)
+		assert.Equal(t, expected, string(contents))
+	}
+
+	// Writing again keeps the same files
+	require.Nil(t, c.writeGenerated())
+	assert.Equal(t, dir, c.tempDir)
+
+	require.Nil(t, c.removeGenerated())
+	_, err := os.Stat(dir)
+	assert.True(t, os.IsNotExist(err))
+	assert.Nil(t, c.tempFiles)
+	require.Nil(t, c.removeGenerated())
+
+	// Without generated content nothing is written
+	c = &Compose{}
+	require.Nil(t, c.writeGenerated())
+	assert.Equal(t, "", c.tempDir)
+}
+
+func TestComposeGeneratedFilesPerCommand(t *testing.T) {
+	c := &Compose{generated: [][]byte{[]byte("first\n")}}
+
+	// Before Start, each command gets files of its own that are removed
+	// once it is done
+	files, done, err := c.generatedFiles()
+	require.Nil(t, err)
+	require.Len(t, files, 1)
+	contents, err := os.ReadFile(files[0])
+	require.Nil(t, err)
+	assert.Equal(t, "first\n", string(contents))
+	assert.Equal(t, "", c.tempDir)
+	done()
+	_, err = os.Stat(filepath.Dir(files[0]))
+	assert.True(t, os.IsNotExist(err))
+
+	// Once Start has written them, commands share them
+	require.Nil(t, c.writeGenerated())
+	defer c.removeGenerated()
+	files, done, err = c.generatedFiles()
+	require.Nil(t, err)
+	assert.Equal(t, c.tempFiles, files)
+	done()
+	assert.FileExists(t, c.tempFiles[0])
+}
+
+func TestComposeFromSources(t *testing.T) {
+	requireCompose(t)
+
+	// Relative bind mounts resolve against the base directory rather
+	// than the temporary directory the content is written to
+	base, err := filepath.Abs(filepath.Join("testdata", "compose"))
+	require.Nil(t, err)
+
+	compose, err := NewComposeWithOptions(ComposeOptions{
+		Name: composeTestName(t),
+		Sources: []ComposeSource{
+			ComposeString(`
+services:
+  app:
+    image: busybox:1.36
+    command: ["sleep", "300"]
+    volumes:
+      - ./:/compose:ro
+`),
+			ComposeFS(fstest.MapFS{"extra.yml": {Data: []byte("services:\n  app:\n    environment:\n      FROM_FS: \"yes\"\n")}}, "extra.yml"),
+		},
+		BaseDir: base,
+	})
+	require.Nil(t, err)
+	defer compose.Cleanup()
+
+	// Nothing is written until the project is started
+	assert.Equal(t, "", compose.tempDir)
+
+	project, err := compose.Config(context.Background())
+	require.Nil(t, err)
+	app := project.Services["app"]
+	assert.Equal(t, "yes", *app.Environment["FROM_FS"])
+	require.Len(t, app.Volumes, 1)
+	assert.Equal(t, base, app.Volumes[0].Source)
+
+	require.Nil(t, compose.Start())
+	result, err := compose.Exec(context.Background(), "app", []string{"ls", "/compose"}, ComposeExecOptions{})
+	require.Nil(t, err)
+	assert.Contains(t, result.Stdout, "full.yml")
+
+	dir := compose.tempDir
+	require.Nil(t, compose.Cleanup())
+	_, err = os.Stat(dir)
+	assert.True(t, os.IsNotExist(err))
+}
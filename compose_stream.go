@@ -0,0 +1,224 @@
+package dockerharness
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/docker/docker/api/types/events"
+)
+
+/*
+ComposeLogsOptions configure how Compose.FollowLogsWithOptions follows
+the project's logs.
+*/
+type ComposeLogsOptions struct {
+	// Services to follow; every service if empty
+	Services []string
+	// NoPrefix leaves out the "service-1 | " prefix on each line
+	NoPrefix bool
+	// NoTimestamps leaves out the timestamp on each line
+	NoTimestamps bool
+	// Since only shows logs after a time, such as "10m" or an RFC 3339
+	// timestamp
+	Since string
+	// Tail is how many of the existing lines to show per container
+	// before following; every line if zero
+	Tail int
+}
+
+/*
+FollowLogs will write the logs of the services, or of every service if
+none are given, to w as they are produced, each line prefixed by its
+service and timestamped. It blocks until the context is done, when it
+returns nil, or until docker compose stops following, such as when the
+project is removed.
+*/
+func (c *Compose) FollowLogs(ctx context.Context, w io.Writer, services ...string) error {
+	return c.FollowLogsWithOptions(ctx, w, ComposeLogsOptions{Services: services})
+}
+
+/*
+FollowLogsWithOptions will follow the project's logs like FollowLogs,
+with control over the services, prefixes, timestamps and where to start.
+*/
+func (c *Compose) FollowLogsWithOptions(ctx context.Context, w io.Writer, options ComposeLogsOptions) error {
+	if w == nil {
+		return errors.New("writer is required")
+	}
+	if options.Tail < 0 {
+		return errors.New("tail cannot be negative")
+	}
+
+	args := []string{"logs", "--follow", "--no-color"}
+	if !options.NoTimestamps {
+		args = append(args, "--timestamps")
+	}
+	if options.NoPrefix {
+		args = append(args, "--no-log-prefix")
+	}
+	if options.Since != "" {
+		args = append(args, "--since", options.Since)
+	}
+	if options.Tail > 0 {
+		args = append(args, "--tail", strconv.Itoa(options.Tail))
+	}
+	args = append(args, options.Services...)
+
+	cmd, stderr, done, err := c.commandContext(ctx, true, args...)
+	if err != nil {
+		return err
+	}
+	defer done()
+
+	cmd.Stdout = w
+	if err := cmd.Run(); err != nil {
+		if ctx.Err() != nil {
+			return nil
+		}
+		return fmt.Errorf("docker compose logs failed: %w: %s", err, strings.TrimSpace(stderr.String()))
+	}
+
+	return nil
+}
+
+/*
+ComposeEvent is a docker event for one of the project's containers, as
+reported by docker compose events, along with the service it belongs
+to.
+*/
+type ComposeEvent struct {
+	Event
+	Service string
+	// Type is the kind of object the event is for, such as "container"
+	Type string
+}
+
+// composeEventMessage is a line of docker compose events --json.
+type composeEventMessage struct {
+	Time       string            `json:"time"`
+	Type       string            `json:"type"`
+	Action     string            `json:"action"`
+	ID         string            `json:"id"`
+	Service    string            `json:"service"`
+	Attributes map[string]string `json:"attributes"`
+}
+
+/*
+Events will stream events for the project's containers, or for those of
+the given services, such as containers starting, dying and changing
+health, until the context is done. Errors, including the context being
+done, are sent on the error channel, after which no more events are
+sent.
+*/
+func (c *Compose) Events(ctx context.Context, services ...string) (<-chan ComposeEvent, <-chan error) {
+	out := make(chan ComposeEvent)
+	errs := make(chan error, 1)
+
+	args := append([]string{"events", "--json"}, services...)
+	cmd, stderr, done, err := c.commandContext(ctx, true, args...)
+	if err != nil {
+		close(out)
+		errs <- err
+		return out, errs
+	}
+	stdout, err := cmd.StdoutPipe()
+	if err != nil {
+		done()
+		close(out)
+		errs <- err
+		return out, errs
+	}
+	if err := cmd.Start(); err != nil {
+		done()
+		close(out)
+		errs <- fmt.Errorf("docker compose events failed: %w", err)
+		return out, errs
+	}
+
+	go func() {
+		defer close(out)
+		defer done()
+
+		scanner := bufio.NewScanner(stdout)
+		for scanner.Scan() {
+			line := bytes.TrimSpace(scanner.Bytes())
+			if len(line) == 0 {
+				continue
+			}
+
+			event, err := parseComposeEvent(line)
+			if err != nil {
+				cmd.Process.Kill()
+				cmd.Wait()
+				errs <- err
+				return
+			}
+
+			select {
+			case out <- event:
+			case <-ctx.Done():
+				cmd.Wait()
+				errs <- ctx.Err()
+				return
+			}
+		}
+
+		if err := scanner.Err(); err != nil {
+			cmd.Process.Kill()
+			cmd.Wait()
+			errs <- fmt.Errorf("failed to read compose events: %w", err)
+			return
+		}
+
+		err := cmd.Wait()
+		switch {
+		case ctx.Err() != nil:
+			errs <- ctx.Err()
+		case err != nil:
+			errs <- fmt.Errorf("docker compose events failed: %w: %s", err, strings.TrimSpace(stderr.String()))
+		default:
+			errs <- errors.New("docker compose events stopped")
+		}
+	}()
+
+	return out, errs
+}
+
+func parseComposeEvent(line []byte) (ComposeEvent, error) {
+	message := composeEventMessage{}
+	if err := json.Unmarshal(line, &message); err != nil {
+		return ComposeEvent{}, fmt.Errorf("failed to parse compose event %q: %w", line, err)
+	}
+
+	// Compose events carry the same attributes as docker's own, so they
+	// are read the same way
+	dockerMessage := events.Message{
+		Type:   events.Type(message.Type),
+		Action: events.Action(message.Action),
+		Actor: events.Actor{
+			ID:         message.ID,
+			Attributes: message.Attributes,
+		},
+	}
+	if message.Time != "" {
+		eventTime, err := time.Parse(time.RFC3339Nano, message.Time)
+		if err != nil {
+			return ComposeEvent{}, fmt.Errorf("failed to parse compose event time %q: %w", message.Time, err)
+		}
+		dockerMessage.TimeNano = eventTime.UnixNano()
+	}
+
+	return ComposeEvent{
+		Event:   toEvent(dockerMessage),
+		Service: message.Service,
+		Type:    message.Type,
+	}, nil
+}
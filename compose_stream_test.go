@@ -0,0 +1,108 @@
+package dockerharness
+
+import (
+	"bytes"
+	"context"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestParseComposeEvent(t *testing.T) {
+	event, err := parseComposeEvent([]byte(`{"action":"health_status: healthy","attributes":{"image":"nginx:alpine","name":"project-web-1"},"id":"abc","service":"web","time":"2024-05-01T10:00:00.5Z","type":"container"}`))
+	require.Nil(t, err)
+	assert.Equal(t, "web", event.Service)
+	assert.Equal(t, "container", event.Type)
+	assert.Equal(t, EventHealthStatus, event.Action)
+	assert.Equal(t, "healthy", event.Health)
+	assert.Equal(t, "abc", event.ContainerID)
+	assert.Equal(t, "project-web-1", event.Name)
+	assert.Equal(t, "nginx:alpine", event.Image)
+	assert.True(t, time.Date(2024, 5, 1, 10, 0, 0, 500000000, time.UTC).Equal(event.Time))
+
+	event, err = parseComposeEvent([]byte(`{"action":"die","attributes":{"exitCode":"137"},"id":"abc","service":"worker","time":"2024-05-01T10:00:00Z","type":"container"}`))
+	require.Nil(t, err)
+	assert.Equal(t, EventDie, event.Action)
+	assert.Equal(t, "137", event.ExitCode)
+
+	_, err = parseComposeEvent([]byte("not json"))
+	require.NotNil(t, err)
+	_, err = parseComposeEvent([]byte(`{"action":"die","time":"yesterday"}`))
+	require.NotNil(t, err)
+}
+
+func TestComposeEventsFailingToStart(t *testing.T) {
+	compose := &Compose{command: []string{filepath.Join(t.TempDir(), "missing-compose")}, workDir: "."}
+
+	composeEvents, errs := compose.Events(context.Background())
+	for range composeEvents {
+		t.Fatal("no events should be sent")
+	}
+	assert.ErrorContains(t, <-errs, "docker compose events failed")
+}
+
+func TestComposeFollowLogs(t *testing.T) {
+	requireCompose(t)
+
+	compose, err := NewCompose(composeTestName(t), []string{composeFile("full.yml")})
+	require.Nil(t, err)
+	defer compose.Cleanup()
+	require.Nil(t, compose.Start())
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	logs := &bytes.Buffer{}
+	require.Nil(t, compose.FollowLogs(ctx, logs, "worker"))
+	assert.Contains(t, logs.String(), "worker-ready")
+	assert.True(t, strings.HasPrefix(logs.String(), "worker"), logs.String())
+
+	ctx, cancel = context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	logs.Reset()
+	require.Nil(t, compose.FollowLogsWithOptions(ctx, logs, ComposeLogsOptions{
+		Services:     []string{"worker"},
+		NoPrefix:     true,
+		NoTimestamps: true,
+	}))
+	assert.Equal(t, "worker-ready\n", logs.String())
+
+	require.NotNil(t, compose.FollowLogs(context.Background(), nil))
+}
+
+func TestComposeEvents(t *testing.T) {
+	requireCompose(t)
+
+	compose, err := NewCompose(composeTestName(t), []string{composeFile("full.yml")})
+	require.Nil(t, err)
+	defer compose.Cleanup()
+	require.Nil(t, compose.Start())
+
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+
+	composeEvents, errs := compose.Events(ctx, "worker")
+	// Give the subscription a moment to be established
+	time.Sleep(time.Second)
+
+	require.Nil(t, compose.KillService("worker", ""))
+
+	for {
+		select {
+		case event := <-composeEvents:
+			if event.Action != EventDie {
+				continue
+			}
+			assert.Equal(t, "worker", event.Service)
+			assert.Equal(t, "137", event.ExitCode)
+			return
+		case err := <-errs:
+			t.Fatal(err)
+		}
+	}
+}
@@ -6,6 +6,7 @@ import (
 	"fmt"
 	"math/rand/v2"
 	"path/filepath"
+	"sort"
 	"strings"
 	"testing"
 
@@ -186,6 +187,241 @@ func TestComposeOptions(t *testing.T) {
 	assert.Equal(t, []string{"envcheck"}, services)
 }
 
+func TestComposeExec(t *testing.T) {
+	requireCompose(t)
+
+	compose, err := NewCompose(composeTestName(t), []string{composeFile("full.yml")})
+	require.Nil(t, err)
+	defer compose.Cleanup()
+
+	err = compose.Start()
+	require.Nil(t, err)
+
+	ctx := context.Background()
+
+	// Output, environment, working directory and user are all passed on
+	result, err := compose.Exec(ctx, "worker", []string{"sh", "-c", "echo $GREETING from $(pwd) as $(id -u)"}, ComposeExecOptions{
+		Index:   1,
+		User:    "1000",
+		WorkDir: "/tmp",
+		Env:     map[string]string{"GREETING": "hello"},
+	})
+	require.Nil(t, err)
+	assert.Equal(t, 0, result.ExitCode)
+	assert.Equal(t, "hello from /tmp as 1000\n", result.Stdout)
+
+	// Standard input reaches the command
+	result, err = compose.Exec(ctx, "worker", []string{"cat"}, ComposeExecOptions{
+		Stdin: strings.NewReader("piped in"),
+	})
+	require.Nil(t, err)
+	assert.Equal(t, "piped in", result.Stdout)
+
+	// A failing command is a result, not an error
+	result, err = compose.Exec(ctx, "worker", []string{"sh", "-c", "echo oops >&2; exit 3"}, ComposeExecOptions{})
+	require.Nil(t, err)
+	assert.Equal(t, 3, result.ExitCode)
+	assert.Equal(t, "oops\n", result.Stderr)
+
+	_, err = compose.Exec(ctx, "", []string{"true"}, ComposeExecOptions{})
+	require.NotNil(t, err)
+	_, err = compose.Exec(ctx, "worker", nil, ComposeExecOptions{})
+	require.NotNil(t, err)
+
+	// Compose failing to exec at all is an error, not an exit code
+	var notRunning *ServiceNotRunningError
+	_, err = compose.Exec(ctx, "missing", []string{"true"}, ComposeExecOptions{})
+	require.ErrorAs(t, err, &notRunning)
+	assert.Equal(t, "missing", notRunning.Service)
+	_, err = compose.Exec(ctx, "worker", []string{"true"}, ComposeExecOptions{Index: 5})
+	require.ErrorAs(t, err, &notRunning)
+	assert.Equal(t, 5, notRunning.Index)
+}
+
+func TestComposeRun(t *testing.T) {
+	requireCompose(t)
+
+	stdout := &bytes.Buffer{}
+	compose, err := NewComposeWithOptions(ComposeOptions{
+		Name:   composeTestName(t),
+		Files:  []string{composeFile("tools.yml")},
+		Stdout: stdout,
+	})
+	require.Nil(t, err)
+	defer compose.Cleanup()
+
+	ctx := context.Background()
+
+	// Services in a profile can be run without starting the project;
+	// their output is captured and streamed
+	result, err := compose.Run(ctx, "migrate", nil, ComposeRunOptions{})
+	require.Nil(t, err)
+	assert.Equal(t, 0, result.ExitCode)
+	assert.Equal(t, "migrated\n", result.Stdout)
+	assert.Contains(t, stdout.String(), "migrated")
+
+	// Options are passed on, and the exit code is returned
+	dir := t.TempDir()
+	result, err = compose.Run(ctx, "migrate", []string{"-c", "cat /seed/$SEED_FILE; exit 4"}, ComposeRunOptions{
+		NoDeps:     true,
+		Entrypoint: "sh",
+		Env:        map[string]string{"SEED_FILE": "seed.sql"},
+		Volumes:    []string{fmt.Sprintf("%s:/seed:ro", dir)},
+	})
+	require.Nil(t, err)
+	assert.Equal(t, 4, result.ExitCode)
+	assert.Contains(t, result.Stderr, "seed.sql")
+
+	_, err = compose.Run(ctx, "", nil, ComposeRunOptions{})
+	require.NotNil(t, err)
+	_, err = compose.Run(ctx, "missing", nil, ComposeRunOptions{})
+	require.ErrorContains(t, err, "unknown compose service missing")
+}
+
+func TestServiceInState(t *testing.T) {
+	assert.True(t, serviceInState([]string{"running", "running"}, serviceRunning))
+	assert.False(t, serviceInState([]string{"running", "exited"}, serviceRunning))
+	assert.False(t, serviceInState(nil, serviceRunning))
+	assert.True(t, serviceInState([]string{"exited", "dead"}, serviceStopped))
+	assert.False(t, serviceInState([]string{"paused"}, serviceStopped))
+	assert.True(t, serviceInState([]string{"paused"}, servicePaused))
+	assert.True(t, serviceInState(nil, serviceRemoved))
+	assert.False(t, serviceInState([]string{"exited"}, serviceRemoved))
+
+	assert.Equal(t, "exited/running", describeServiceStates([]string{"running", "exited", "running"}))
+	assert.Equal(t, "not created", describeServiceStates(nil))
+}
+
+func TestComposeServiceLifecycle(t *testing.T) {
+	requireCompose(t)
+
+	compose, err := NewCompose(composeTestName(t), []string{composeFile("full.yml")})
+	require.Nil(t, err)
+	defer compose.Cleanup()
+
+	err = compose.Start()
+	require.Nil(t, err)
+
+	// Each change to the worker leaves the web service running
+	serviceState := func(service string) string {
+		containers, err := compose.GetContainers()
+		require.Nil(t, err)
+		for _, container := range containers {
+			if container.Service == service {
+				return container.State
+			}
+		}
+		return ""
+	}
+
+	require.Nil(t, compose.StopService("worker", 1))
+	assert.Equal(t, "exited", serviceState("worker"))
+	assert.Equal(t, "running", serviceState("web"))
+
+	require.Nil(t, compose.StartService("worker"))
+	assert.Equal(t, "running", serviceState("worker"))
+
+	require.Nil(t, compose.PauseService("worker"))
+	assert.Equal(t, "paused", serviceState("worker"))
+	require.Nil(t, compose.UnpauseService("worker"))
+	assert.Equal(t, "running", serviceState("worker"))
+
+	require.Nil(t, compose.RestartService("worker", 1))
+	assert.Equal(t, "running", serviceState("worker"))
+
+	require.Nil(t, compose.KillService("worker", ""))
+	assert.Equal(t, "exited", serviceState("worker"))
+
+	require.Nil(t, compose.RemoveService("worker", true))
+	assert.Equal(t, "", serviceState("worker"))
+	assert.Equal(t, "running", serviceState("web"))
+
+	// Removed services can be brought back
+	require.Nil(t, compose.StartService("worker"))
+	assert.Equal(t, "running", serviceState("worker"))
+
+	require.NotNil(t, compose.StopService("", 0))
+}
+
+func TestReplicaIndex(t *testing.T) {
+	assert.Equal(t, 3, replicaIndex(ComposeContainer{
+		Name:   "project-web-1",
+		Labels: "com.docker.compose.project=project,com.docker.compose.container-number=3,com.docker.compose.service=web",
+	}))
+	assert.Equal(t, 2, replicaIndex(ComposeContainer{Name: "project-web-2"}))
+	assert.Equal(t, 1, replicaIndex(ComposeContainer{Name: "project_web_1"}))
+	assert.Equal(t, 0, replicaIndex(ComposeContainer{Name: "custom-name"}))
+}
+
+func TestComposeScale(t *testing.T) {
+	requireCompose(t)
+
+	compose, err := NewComposeWithOptions(ComposeOptions{
+		Name:  composeTestName(t),
+		Files: []string{composeFile("full.yml")},
+		Scale: map[string]int{"web": 2},
+	})
+	require.Nil(t, err)
+	defer compose.Cleanup()
+
+	err = compose.Start()
+	require.Nil(t, err)
+
+	replicas := func(service string) []int {
+		containers, err := compose.GetContainers()
+		require.Nil(t, err)
+		indexes := []int{}
+		for _, container := range containers {
+			if container.Service == service {
+				indexes = append(indexes, container.Index)
+			}
+		}
+		sort.Ints(indexes)
+		return indexes
+	}
+	assert.Equal(t, []int{1, 2}, replicas("web"))
+
+	addresses, err := compose.GetPorts("web", 80)
+	require.Nil(t, err)
+	require.Len(t, addresses, 2)
+	assert.NotEqual(t, addresses[0], addresses[1])
+
+	second, err := compose.GetPort("web", 80, "tcp", 2)
+	require.Nil(t, err)
+	assert.Equal(t, addresses[1], second)
+
+	require.Nil(t, compose.Scale(context.Background(), map[string]int{"web": 3}))
+	assert.Equal(t, []int{1, 2, 3}, replicas("web"))
+	assert.Equal(t, []int{1}, replicas("worker"))
+
+	require.Nil(t, compose.Scale(context.Background(), map[string]int{"web": 1}))
+	assert.Equal(t, []int{1}, replicas("web"))
+
+	require.NotNil(t, compose.Scale(context.Background(), map[string]int{"web": -1}))
+	_, err = compose.GetPort("web", 80, "tcp", 0)
+	require.NotNil(t, err)
+}
+
+func TestComposeStartServiceKeepsScale(t *testing.T) {
+	requireCompose(t)
+
+	compose, err := NewComposeWithOptions(ComposeOptions{
+		Name:  composeTestName(t),
+		Files: []string{composeFile("full.yml")},
+		Scale: map[string]int{"web": 2},
+	})
+	require.Nil(t, err)
+	defer compose.Cleanup()
+	require.Nil(t, compose.Start())
+
+	require.Nil(t, compose.StopService("web", 1))
+	require.Nil(t, compose.StartService("web"))
+
+	addresses, err := compose.GetPorts("web", 80)
+	require.Nil(t, err)
+	assert.Len(t, addresses, 2)
+}
+
 func requireCompose(t *testing.T) {
 	t.Helper()
 
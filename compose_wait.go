@@ -0,0 +1,104 @@
+package dockerharness
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// composeWaitLogLines is how much of a service's logs is included when
+// it fails to become ready.
+const composeWaitLogLines = 50
+
+// composeServiceTarget lets the container wait strategies check a
+// compose service, through its first replica.
+type composeServiceTarget struct {
+	compose *Compose
+	service string
+}
+
+var _ WaitTarget = composeServiceTarget{}
+
+func (t composeServiceTarget) HostAddress(ctx context.Context, port string) (string, error) {
+	privatePort, protocol, _ := strings.Cut(normalizePort(port), "/")
+	number, err := strconv.Atoi(privatePort)
+	if err != nil {
+		return "", fmt.Errorf("invalid port %q: %w", port, err)
+	}
+
+	address, err := t.compose.GetPort(t.service, number, protocol)
+	if err != nil {
+		return "", err
+	}
+	host, hostPort, err := net.SplitHostPort(address)
+	if err != nil || hostPort == "0" {
+		return "", fmt.Errorf("port %s is not published", port)
+	}
+	// Ports published on every interface are reachable locally
+	if ip := net.ParseIP(host); ip == nil || ip.IsUnspecified() {
+		host = "127.0.0.1"
+	}
+
+	return net.JoinHostPort(host, hostPort), nil
+}
+
+func (t composeServiceTarget) Logs(ctx context.Context) (string, error) {
+	out, err := t.compose.outputContext(ctx, "logs", "--no-color", "--no-log-prefix", t.service)
+	if err != nil {
+		return "", err
+	}
+	return string(out), nil
+}
+
+func (t composeServiceTarget) Exec(ctx context.Context, cmd []string) (ExecResult, error) {
+	return t.compose.Exec(ctx, t.service, cmd, ComposeExecOptions{})
+}
+
+// waitForServices checks every service's wait strategies concurrently,
+// within the wait timeout, reporting each service that did not become
+// ready along with the end of its logs.
+func (c *Compose) waitForServices() error {
+	ctx, cancel := context.WithTimeout(context.Background(), c.waitTimeout)
+	defer cancel()
+
+	errs := make([]error, len(c.waitFor))
+	wg := sync.WaitGroup{}
+
+	for i, service := range sortedKeys(c.waitFor) {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+
+			errs[i] = c.waitForService(ctx, service)
+		}()
+	}
+	wg.Wait()
+
+	return errors.Join(errs...)
+}
+
+// waitForService checks a single service's wait strategies in order,
+// reporting the service along with the end of its logs if it does not
+// become ready.
+func (c *Compose) waitForService(ctx context.Context, service string) error {
+	target := composeServiceTarget{compose: c, service: service}
+	for _, strategy := range c.waitFor[service] {
+		if err := strategy.WaitUntilReady(ctx, target); err != nil {
+			return c.serviceNotReady(service, err)
+		}
+	}
+	return nil
+}
+
+func (c *Compose) serviceNotReady(service string, err error) error {
+	logs, logsErr := c.output("logs", "--no-color", "--no-log-prefix", "--tail", strconv.Itoa(composeWaitLogLines), service)
+	if logsErr != nil {
+		return fmt.Errorf("service %s was not ready: %w (logs unavailable: %v)", service, err, logsErr)
+	}
+
+	return fmt.Errorf("service %s was not ready: %w\nlast %d lines of %s logs:\n%s", service, err, composeWaitLogLines, service, strings.TrimRight(string(logs), "\n"))
+}
@@ -0,0 +1,111 @@
+package dockerharness
+
+import (
+	"context"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestComposeWaitForOptions(t *testing.T) {
+	requireCompose(t)
+
+	_, err := NewComposeWithOptions(ComposeOptions{
+		Files:   []string{composeFile("full.yml")},
+		WaitFor: map[string][]WaitStrategy{"web": {nil}},
+	})
+	require.NotNil(t, err)
+
+	_, err = NewComposeWithOptions(ComposeOptions{
+		Files:   []string{composeFile("full.yml")},
+		WaitFor: map[string][]WaitStrategy{"wbe": {WaitForPort("80")}},
+	})
+	require.NotNil(t, err)
+	assert.Contains(t, err.Error(), "wbe")
+}
+
+func TestComposeWaitFor(t *testing.T) {
+	requireCompose(t)
+
+	compose, err := NewComposeWithOptions(ComposeOptions{
+		Name:   composeTestName(t),
+		Files:  []string{composeFile("full.yml")},
+		NoWait: true,
+		WaitFor: map[string][]WaitStrategy{
+			"web": {WaitForPort("80"), WaitForHTTP("80", "/")},
+			"worker": {
+				WaitForLog("worker-ready"),
+				WaitForExec("test", "-d", "/data"),
+			},
+		},
+	})
+	require.Nil(t, err)
+	defer compose.Cleanup()
+
+	require.Nil(t, compose.Start())
+}
+
+func TestComposeWaitForReportsFailures(t *testing.T) {
+	requireCompose(t)
+
+	compose, err := NewComposeWithOptions(ComposeOptions{
+		Name:        composeTestName(t),
+		Files:       []string{composeFile("full.yml")},
+		NoWait:      true,
+		WaitTimeout: 3 * time.Second,
+		WaitFor: map[string][]WaitStrategy{
+			"web":    {WaitForPort("80")},
+			"worker": {WaitForLog("never logged")},
+		},
+	})
+	require.Nil(t, err)
+	defer compose.Cleanup()
+
+	err = compose.Start()
+	require.NotNil(t, err)
+	assert.Contains(t, err.Error(), "service worker was not ready")
+	assert.NotContains(t, err.Error(), "service web")
+	// The failing service's logs are included
+	assert.Contains(t, err.Error(), "worker-ready")
+}
+
+// countingWait is ready straight away, counting how often it is checked.
+type countingWait struct {
+	checks atomic.Int64
+}
+
+func (w *countingWait) WaitUntilReady(ctx context.Context, target WaitTarget) error {
+	w.checks.Add(1)
+	return nil
+}
+
+func TestComposeStartServiceWaitsFor(t *testing.T) {
+	requireCompose(t)
+
+	wait := &countingWait{}
+	compose, err := NewComposeWithOptions(ComposeOptions{
+		Name:    composeTestName(t),
+		Files:   []string{composeFile("full.yml")},
+		NoWait:  true,
+		WaitFor: map[string][]WaitStrategy{"worker": {wait, WaitForExec("test", "-d", "/data")}},
+	})
+	require.Nil(t, err)
+	defer compose.Cleanup()
+
+	require.Nil(t, compose.Start())
+	assert.Equal(t, int64(1), wait.checks.Load())
+
+	require.Nil(t, compose.StopService("worker", 1))
+	require.Nil(t, compose.StartService("worker"))
+	assert.Equal(t, int64(2), wait.checks.Load())
+
+	require.Nil(t, compose.RestartService("worker", 1))
+	assert.Equal(t, int64(3), wait.checks.Load())
+
+	// Services without strategies are not checked
+	require.Nil(t, compose.RestartService("web", 1))
+	assert.Equal(t, int64(3), wait.checks.Load())
+}
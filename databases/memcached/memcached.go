@@ -1,7 +1,9 @@
 package memcached
 
 import (
+	"errors"
 	"fmt"
+	"net"
 	"time"
 
 	"github.com/bradfitz/gomemcache/memcache"
@@ -15,16 +17,31 @@ type Memcached struct {
 	port      string
 }
 
+var _ harness.Harness = (*Memcached)(nil)
+var _ harness.EnvExporter = (*Memcached)(nil)
+
 func NewMemcached(name string) (*Memcached, error) {
-	container, err := harness.NewContainer(
-		name,
-		"memcached",
-		"",
-		map[string]string{
+	return newMemcached(name, false)
+}
+
+/*
+NewSharedMemcached will create a memcached harness using a shared
+container, so that every test process talks to the same memcached.
+*/
+func NewSharedMemcached() (*Memcached, error) {
+	return newMemcached("", true)
+}
+
+func newMemcached(name string, shared bool) (*Memcached, error) {
+	container, err := harness.NewContainerWithOptions(harness.ContainerOptions{
+		Name:  name,
+		Image: "memcached",
+		Ports: map[string]string{
 			"11211": "",
 		},
-		map[string]string{},
-	)
+		Env:    map[string]string{},
+		Shared: shared,
+	})
 	if err != nil {
 		return nil, err
 	}
@@ -56,6 +73,8 @@ func (m *Memcached) Create() error {
 		return fmt.Errorf("container failed to start within timeout")
 	}
 
+	harness.Unregister(m.container)
+	harness.Register(m)
 	return nil
 }
 
@@ -95,13 +114,48 @@ func (m *Memcached) ConnectWithTimeout(timeout time.Duration) (*memcache.Client,
 	return client, nil
 }
 
+/*
+ExportEnv will publish the address of the running memcached server as
+MEMCACHED_ADDR.
+*/
+func (m *Memcached) ExportEnv() (map[string]string, error) {
+	if m.port == "" {
+		return nil, errors.New("memcached has not been created")
+	}
+
+	return map[string]string{
+		"MEMCACHED_ADDR": net.JoinHostPort("127.0.0.1", m.port),
+	}, nil
+}
+
 func (m *Memcached) GetClient() *memcache.Client {
 	return m.client
 }
 
+func (m *Memcached) GetContainer() *harness.Container {
+	return m.container
+}
+
+func (m *Memcached) Start() error {
+	return m.Create()
+}
+
+func (m *Memcached) Stop(wait int) error {
+	return m.container.Stop(wait)
+}
+
+func (m *Memcached) IsRunning() (bool, error) {
+	return m.container.IsRunning()
+}
+
 func (m *Memcached) Cleanup() error {
 	if m.client != nil {
 		m.client.Close()
 	}
-	return m.container.Cleanup()
+	if err := m.container.Cleanup(); err != nil {
+		return err
+	}
+
+	harness.Unregister(m)
+	return nil
 }
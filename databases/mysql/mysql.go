@@ -2,7 +2,10 @@ package mysql
 
 import (
 	"database/sql"
+	"errors"
 	"fmt"
+	"net"
+	"net/url"
 	"time"
 
 	"github.com/go-sql-driver/mysql"
@@ -23,7 +26,22 @@ type Mysql struct {
 	port      string
 }
 
+var _ harness.Harness = (*Mysql)(nil)
+var _ harness.EnvExporter = (*Mysql)(nil)
+
 func NewMysql(name string, tag string, username string, password string, database string) (*Mysql, error) {
+	return newMysql(name, tag, username, password, database, false)
+}
+
+/*
+NewSharedMysql will create a mysql harness backed by a shared container,
+reused by every process asking for the same tag and credentials.
+*/
+func NewSharedMysql(tag string, username string, password string, database string) (*Mysql, error) {
+	return newMysql("", tag, username, password, database, true)
+}
+
+func newMysql(name string, tag string, username string, password string, database string, shared bool) (*Mysql, error) {
 	// Build environment variables based on whether we're using root or a regular user
 	env := make(map[string]string)
 
@@ -40,15 +58,16 @@ func NewMysql(name string, tag string, username string, password string, databas
 		env["MYSQL_DATABASE"] = database
 	}
 
-	container, err := harness.NewContainer(
-		name,
-		"mysql",
-		tag,
-		map[string]string{
+	container, err := harness.NewContainerWithOptions(harness.ContainerOptions{
+		Name:  name,
+		Image: "mysql",
+		Tag:   tag,
+		Ports: map[string]string{
 			"3306": "",
 		},
-		env,
-	)
+		Env:    env,
+		Shared: shared,
+	})
 	if err != nil {
 		return nil, err
 	}
@@ -113,6 +132,8 @@ func (m *Mysql) Create() error {
 			if err == nil {
 				// MySQL is ready!
 				db.Close()
+				harness.Unregister(m.container)
+				harness.Register(m)
 				return nil
 			}
 			db.Close()
@@ -124,6 +145,42 @@ func (m *Mysql) Create() error {
 	return fmt.Errorf("MySQL failed to become ready within %v", readyTimeout)
 }
 
+/*
+ExportEnv will publish the connection details of the running database
+as DATABASE_URL, MYSQL_DSN in the go-sql-driver format, and the
+MYSQL_HOST and MYSQL_TCP_PORT variables read by the mysql client
+alongside MYSQL_USER, MYSQL_PASSWORD and MYSQL_DATABASE.
+*/
+func (m *Mysql) ExportEnv() (map[string]string, error) {
+	if m.port == "" {
+		return nil, errors.New("mysql has not been created")
+	}
+
+	address := net.JoinHostPort("127.0.0.1", m.port)
+	databaseURL := url.URL{
+		Scheme: "mysql",
+		User:   url.UserPassword(m.username, m.password),
+		Host:   address,
+		Path:   "/" + m.database,
+	}
+	config := mysql.NewConfig()
+	config.User = m.username
+	config.Passwd = m.password
+	config.Net = "tcp"
+	config.Addr = address
+	config.DBName = m.database
+
+	return map[string]string{
+		"DATABASE_URL":   databaseURL.String(),
+		"MYSQL_DSN":      config.FormatDSN(),
+		"MYSQL_HOST":     "127.0.0.1",
+		"MYSQL_TCP_PORT": m.port,
+		"MYSQL_USER":     m.username,
+		"MYSQL_PASSWORD": m.password,
+		"MYSQL_DATABASE": m.database,
+	}, nil
+}
+
 func (m *Mysql) Connect() (*sql.DB, error) {
 	connectionString := fmt.Sprintf(
 		"%s:%s@tcp(localhost:%s)/%s",
@@ -197,9 +254,26 @@ func (m *Mysql) GetContainer() *harness.Container {
 	return m.container
 }
 
+func (m *Mysql) Start() error {
+	return m.Create()
+}
+
+func (m *Mysql) Stop(wait int) error {
+	return m.container.Stop(wait)
+}
+
+func (m *Mysql) IsRunning() (bool, error) {
+	return m.container.IsRunning()
+}
+
 func (m *Mysql) Cleanup() error {
 	if m.db != nil {
 		m.db.Close()
 	}
-	return m.container.Cleanup()
+	if err := m.container.Cleanup(); err != nil {
+		return err
+	}
+
+	harness.Unregister(m)
+	return nil
 }
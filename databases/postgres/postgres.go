@@ -2,7 +2,10 @@ package postgres
 
 import (
 	"database/sql"
+	"errors"
 	"fmt"
+	"net"
+	"net/url"
 	"time"
 
 	harness "github.com/hlfshell/docker-harness"
@@ -19,20 +22,37 @@ type Postgres struct {
 	port      string
 }
 
+var _ harness.Harness = (*Postgres)(nil)
+var _ harness.EnvExporter = (*Postgres)(nil)
+
 func NewPostgres(name string, tag string, username string, password string, database string) (*Postgres, error) {
-	container, err := harness.NewContainer(
-		name,
-		"postgres",
-		tag,
-		map[string]string{
+	return newPostgres(name, tag, username, password, database, false)
+}
+
+/*
+NewSharedPostgres will create a postgres harness backed by a shared
+container, reused by every process asking for the same tag and
+credentials. See harness.ContainerOptions.Shared.
+*/
+func NewSharedPostgres(tag string, username string, password string, database string) (*Postgres, error) {
+	return newPostgres("", tag, username, password, database, true)
+}
+
+func newPostgres(name string, tag string, username string, password string, database string, shared bool) (*Postgres, error) {
+	container, err := harness.NewContainerWithOptions(harness.ContainerOptions{
+		Name:  name,
+		Image: "postgres",
+		Tag:   tag,
+		Ports: map[string]string{
 			"5432": "",
 		},
-		map[string]string{
+		Env: map[string]string{
 			"POSTGRES_USER":     username,
 			"POSTGRES_PASSWORD": password,
 			"POSTGRES_DB":       database,
 		},
-	)
+		Shared: shared,
+	})
 	if err != nil {
 		return nil, err
 	}
@@ -69,6 +89,8 @@ func (p *Postgres) Create() error {
 		p.container.Cleanup()
 		return fmt.Errorf("container failed to start within timeout")
 	}
+	harness.Unregister(p.container)
+	harness.Register(p)
 	return nil
 }
 
@@ -112,6 +134,35 @@ func (p *Postgres) Connect() (*sql.DB, error) {
 	return db, nil
 }
 
+/*
+ExportEnv will publish the connection details of the running database
+as DATABASE_URL and the PGHOST, PGPORT, PGUSER, PGPASSWORD and
+PGDATABASE variables read by libpq-based clients.
+*/
+func (p *Postgres) ExportEnv() (map[string]string, error) {
+	if p.port == "" {
+		return nil, errors.New("postgres has not been created")
+	}
+
+	databaseURL := url.URL{
+		Scheme:   "postgresql",
+		User:     url.UserPassword(p.username, p.password),
+		Host:     net.JoinHostPort("127.0.0.1", p.port),
+		Path:     "/" + p.database,
+		RawQuery: "sslmode=disable",
+	}
+
+	return map[string]string{
+		"DATABASE_URL": databaseURL.String(),
+		"PGHOST":       "127.0.0.1",
+		"PGPORT":       p.port,
+		"PGUSER":       p.username,
+		"PGPASSWORD":   p.password,
+		"PGDATABASE":   p.database,
+		"PGSSLMODE":    "disable",
+	}, nil
+}
+
 func (p *Postgres) GetDB() *sql.DB {
 	return p.db
 }
@@ -120,9 +171,26 @@ func (p *Postgres) GetContainer() *harness.Container {
 	return p.container
 }
 
+func (p *Postgres) Start() error {
+	return p.Create()
+}
+
+func (p *Postgres) Stop(wait int) error {
+	return p.container.Stop(wait)
+}
+
+func (p *Postgres) IsRunning() (bool, error) {
+	return p.container.IsRunning()
+}
+
 func (p *Postgres) Cleanup() error {
 	if p.db != nil {
 		p.db.Close()
 	}
-	return p.container.Cleanup()
+	if err := p.container.Cleanup(); err != nil {
+		return err
+	}
+
+	harness.Unregister(p)
+	return nil
 }
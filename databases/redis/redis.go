@@ -2,7 +2,9 @@ package redis
 
 import (
 	"context"
+	"errors"
 	"fmt"
+	"net"
 	"time"
 
 	harness "github.com/hlfshell/docker-harness"
@@ -16,16 +18,31 @@ type Redis struct {
 	port      string
 }
 
+var _ harness.Harness = (*Redis)(nil)
+var _ harness.EnvExporter = (*Redis)(nil)
+
 func NewRedis(name string) (*Redis, error) {
-	container, err := harness.NewContainer(
-		name,
-		"redis",
-		"",
-		map[string]string{
+	return newRedis(name, false)
+}
+
+/*
+NewSharedRedis will create a redis harness on the one shared redis
+container.
+*/
+func NewSharedRedis() (*Redis, error) {
+	return newRedis("", true)
+}
+
+func newRedis(name string, shared bool) (*Redis, error) {
+	container, err := harness.NewContainerWithOptions(harness.ContainerOptions{
+		Name:  name,
+		Image: "redis",
+		Ports: map[string]string{
 			"6379": "",
 		},
-		map[string]string{},
-	)
+		Env:    map[string]string{},
+		Shared: shared,
+	})
 	if err != nil {
 		return nil, fmt.Errorf("failed to create redis container: %w", err)
 	}
@@ -54,6 +71,8 @@ func (r *Redis) Create() error {
 		return fmt.Errorf("container failed to start")
 	}
 
+	harness.Unregister(r.container)
+	harness.Register(r)
 	return nil
 }
 
@@ -99,13 +118,50 @@ func (r *Redis) ConnectWithTimeout(timeout time.Duration) (*redis.Client, error)
 	return client, nil
 }
 
+/*
+ExportEnv will publish the address of the running redis server as
+REDIS_ADDR and REDIS_URL.
+*/
+func (r *Redis) ExportEnv() (map[string]string, error) {
+	if r.port == "" {
+		return nil, errors.New("redis has not been created")
+	}
+
+	address := net.JoinHostPort("127.0.0.1", r.port)
+	return map[string]string{
+		"REDIS_ADDR": address,
+		"REDIS_URL":  fmt.Sprintf("redis://%s/0", address),
+	}, nil
+}
+
 func (r *Redis) GetClient() *redis.Client {
 	return r.client
 }
 
+func (r *Redis) GetContainer() *harness.Container {
+	return r.container
+}
+
+func (r *Redis) Start() error {
+	return r.Create()
+}
+
+func (r *Redis) Stop(wait int) error {
+	return r.container.Stop(wait)
+}
+
+func (r *Redis) IsRunning() (bool, error) {
+	return r.container.IsRunning()
+}
+
 func (r *Redis) Cleanup() error {
 	if r.client != nil {
 		r.client.Close()
 	}
-	return r.container.Cleanup()
+	if err := r.container.Cleanup(); err != nil {
+		return err
+	}
+
+	harness.Unregister(r)
+	return nil
 }
@@ -0,0 +1,169 @@
+package dockerharness
+
+import (
+	"fmt"
+	"os"
+	"os/exec"
+	"regexp"
+	"strings"
+	"testing"
+)
+
+/*
+EnvExporter is anything that can publish the values needed to connect
+to it as named environment variables, such as DATABASE_URL. Containers,
+stacks, compose projects and the database modules are all exporters.
+*/
+type EnvExporter interface {
+	ExportEnv() (map[string]string, error)
+}
+
+/*
+Environment is a set of environment variables collected from one or
+more exporters.
+*/
+type Environment map[string]string
+
+/*
+CollectEnv will gather the environment variables of every exporter into
+a single Environment. Two exporters publishing the same variable with
+different values is an error; use PrefixEnv to tell them apart.
+*/
+func CollectEnv(exporters ...EnvExporter) (Environment, error) {
+	env := Environment{}
+	for _, exporter := range exporters {
+		values, err := exporter.ExportEnv()
+		if err != nil {
+			return nil, err
+		}
+		for k, v := range values {
+			if existing, ok := env[k]; ok && existing != v {
+				return nil, fmt.Errorf("environment variable %s is exported more than once with different values", k)
+			}
+			env[k] = v
+		}
+	}
+	return env, nil
+}
+
+type prefixedExporter struct {
+	prefix   string
+	exporter EnvExporter
+}
+
+/*
+PrefixEnv will prefix every variable the exporter publishes, so that
+two harnesses of the same kind can be collected together, such as
+ORDERS_DATABASE_URL and USERS_DATABASE_URL.
+*/
+func PrefixEnv(prefix string, exporter EnvExporter) EnvExporter {
+	return &prefixedExporter{prefix: prefix, exporter: exporter}
+}
+
+func (p *prefixedExporter) ExportEnv() (map[string]string, error) {
+	values, err := p.exporter.ExportEnv()
+	if err != nil {
+		return nil, err
+	}
+
+	prefixed := map[string]string{}
+	for k, v := range values {
+		prefixed[p.prefix+k] = v
+	}
+	return prefixed, nil
+}
+
+/*
+Keys returns the variable names in sorted order.
+*/
+func (e Environment) Keys() []string {
+	return sortedKeys(e)
+}
+
+/*
+Setenv will set every variable in the current process's environment.
+*/
+func (e Environment) Setenv() error {
+	for _, k := range e.Keys() {
+		if err := os.Setenv(k, e[k]); err != nil {
+			return fmt.Errorf("failed to set %s: %w", k, err)
+		}
+	}
+	return nil
+}
+
+/*
+SetenvTB will set every variable for the duration of a test with
+t.Setenv, restoring the previous values when the test ends.
+*/
+func (e Environment) SetenvTB(t testing.TB) {
+	t.Helper()
+
+	for _, k := range e.Keys() {
+		t.Setenv(k, e[k])
+	}
+}
+
+/*
+ApplyToCmd will add every variable to the command's environment. If the
+command has no environment set it starts from the current process's
+environment, as exec.Cmd itself would.
+*/
+func (e Environment) ApplyToCmd(cmd *exec.Cmd) {
+	if cmd.Env == nil {
+		cmd.Env = os.Environ()
+	}
+	for _, k := range e.Keys() {
+		cmd.Env = append(cmd.Env, fmt.Sprintf("%s=%s", k, e[k]))
+	}
+}
+
+/*
+WriteDotenv will write the variables to a .env file, one KEY=value per
+line in sorted order, quoting values where needed.
+*/
+func (e Environment) WriteDotenv(path string) error {
+	return os.WriteFile(path, []byte(e.Dotenv()), 0o600)
+}
+
+/*
+Dotenv returns the variables in .env file format.
+*/
+func (e Environment) Dotenv() string {
+	builder := strings.Builder{}
+	for _, k := range e.Keys() {
+		fmt.Fprintf(&builder, "%s=%s\n", k, dotenvQuote(e[k]))
+	}
+	return builder.String()
+}
+
+var dotenvPlain = regexp.MustCompile(`^[A-Za-z0-9_./:@,+=%-]*$`)
+
+func dotenvQuote(value string) string {
+	if dotenvPlain.MatchString(value) {
+		return value
+	}
+
+	replacer := strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`, "$", `\$`)
+	return fmt.Sprintf(`"%s"`, replacer.Replace(value))
+}
+
+var envNameInvalid = regexp.MustCompile(`[^A-Z0-9_]+`)
+
+// envName turns a container, service or image name into a prefix for
+// environment variable names, such as "my-db" into "MY_DB".
+func envName(name string) string {
+	name = strings.ToUpper(name)
+	name = envNameInvalid.ReplaceAllString(name, "_")
+	return strings.Trim(name, "_")
+}
+
+// portEnvName names a port for environment variables, leaving tcp ports
+// bare and suffixing other protocols, such as "53/udp" into "53_UDP".
+func portEnvName(port string) string {
+	number, protocol, _ := strings.Cut(port, "/")
+	if protocol == "" || protocol == "tcp" {
+		return number
+	}
+	return fmt.Sprintf("%s_%s", number, strings.ToUpper(protocol))
+}
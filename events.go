@@ -0,0 +1,214 @@
+package dockerharness
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/docker/docker/api/types/events"
+	"github.com/docker/docker/api/types/filters"
+	docker "github.com/docker/docker/client"
+)
+
+const (
+	EventDie          = "die"
+	EventOOM          = "oom"
+	EventHealthStatus = "health_status"
+	EventRestart      = "restart"
+	EventKill         = "kill"
+)
+
+// watchedEvents are the container events streamed by Events; the ones
+// that tell a test something has gone wrong with a dependency.
+var watchedEvents = []string{EventDie, EventOOM, EventHealthStatus, EventRestart, EventKill}
+
+/*
+Event is a docker event for a container, such as it dying or changing
+health.
+*/
+type Event struct {
+	Time        time.Time
+	Action      string
+	ContainerID string
+	Name        string
+	Image       string
+	// ExitCode is set for die events
+	ExitCode string
+	// Health is "healthy" or "unhealthy" for health_status events
+	Health string
+	// Signal is set for kill events
+	Signal string
+	// Attributes are every attribute docker reported for the event,
+	// including the container's labels
+	Attributes map[string]string
+}
+
+/*
+Events will stream die, oom, health_status, restart and kill events for
+every container created by this process (see SessionID) until the
+context is done. Errors, including the context being done, are sent on
+the error channel, after which no more events are sent.
+*/
+func Events(ctx context.Context) (<-chan Event, <-chan error) {
+	client, err := docker.NewClientWithOpts(docker.FromEnv, docker.WithAPIVersionNegotiation())
+	if err != nil {
+		errs := make(chan error, 1)
+		errs <- err
+		return make(chan Event), errs
+	}
+
+	args := filters.NewArgs(filters.Arg("label", fmt.Sprintf("%s=%s", LabelSession, sessionID)))
+	messages, errs := streamEvents(ctx, client, args)
+
+	// The client is only needed for as long as the stream
+	done := make(chan error, 1)
+	go func() {
+		err := <-errs
+		client.Close()
+		done <- err
+	}()
+
+	return messages, done
+}
+
+/*
+Events will stream die, oom, health_status, restart and kill events for
+the container until the context is done. Errors, including the context
+being done, are sent on the error channel, after which no more events
+are sent.
+*/
+func (c *Container) Events(ctx context.Context) (<-chan Event, <-chan error) {
+	if c.id == "" {
+		errs := make(chan error, 1)
+		errs <- errors.New("container has not been started")
+		return make(chan Event), errs
+	}
+
+	return streamEvents(ctx, c.client, filters.NewArgs(filters.Arg("container", c.id)))
+}
+
+// streamEvents subscribes to the watched container events matching the
+// filters and converts them into Events.
+func streamEvents(ctx context.Context, client *docker.Client, args filters.Args) (<-chan Event, <-chan error) {
+	args.Add("type", string(events.ContainerEventType))
+	for _, action := range watchedEvents {
+		args.Add("event", action)
+	}
+
+	messages, errs := client.Events(ctx, events.ListOptions{Filters: args})
+
+	out := make(chan Event)
+	outErrs := make(chan error, 1)
+	go func() {
+		defer close(out)
+		for {
+			select {
+			case message := <-messages:
+				select {
+				case out <- toEvent(message):
+				case <-ctx.Done():
+					outErrs <- ctx.Err()
+					return
+				}
+			case err := <-errs:
+				outErrs <- err
+				return
+			}
+		}
+	}()
+
+	return out, outErrs
+}
+
+func toEvent(message events.Message) Event {
+	event := Event{
+		Time:        time.Unix(0, message.TimeNano),
+		Action:      string(message.Action),
+		ContainerID: message.Actor.ID,
+		Name:        message.Actor.Attributes["name"],
+		Image:       message.Actor.Attributes["image"],
+		ExitCode:    message.Actor.Attributes["exitCode"],
+		Signal:      message.Actor.Attributes["signal"],
+		Attributes:  message.Actor.Attributes,
+	}
+	if message.TimeNano == 0 {
+		event.Time = time.Unix(message.Time, 0)
+	}
+
+	// Health events carry their status in the action, such as
+	// "health_status: healthy"
+	if action, health, ok := strings.Cut(event.Action, ":"); ok {
+		event.Action = strings.TrimSpace(action)
+		event.Health = strings.TrimSpace(health)
+	}
+
+	return event
+}
+
+/*
+FailOnDeath will fail the test as soon as any of the containers dies
+without having been stopped or cleaned up, rather than leaving the test
+to run into a confusing connection error later. The returned context is
+cancelled when that happens, so that work using it stops early too.
+Watching stops when the test ends.
+*/
+func FailOnDeath(t testing.TB, containers ...*Container) context.Context {
+	t.Helper()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	wg := sync.WaitGroup{}
+	t.Cleanup(func() {
+		cancel()
+		wg.Wait()
+	})
+
+	for _, c := range containers {
+		if c.id == "" {
+			t.Fatalf("cannot watch container %s: it has not been started", c.describe())
+		}
+
+		messages, errs := c.Events(ctx)
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+
+			oom := false
+			for {
+				select {
+				case event, ok := <-messages:
+					if !ok {
+						// The error explaining why is on its way
+						messages = nil
+						continue
+					}
+					switch event.Action {
+					case EventOOM:
+						oom = true
+					case EventDie:
+						if c.stopping.Load() {
+							continue
+						}
+						reason := fmt.Sprintf("exit code %s", event.ExitCode)
+						if oom {
+							reason = "out of memory"
+						}
+						t.Errorf("container %s died unexpectedly: %s", c.describe(), reason)
+						cancel()
+						return
+					}
+				case err := <-errs:
+					if ctx.Err() == nil {
+						t.Errorf("stopped watching container %s: %v", c.describe(), err)
+					}
+					return
+				}
+			}
+		}()
+	}
+
+	return ctx
+}
@@ -0,0 +1,138 @@
+package dockerharness
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/docker/docker/api/types/events"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// recordingTB captures failures instead of failing the real test.
+type recordingTB struct {
+	testing.TB
+	lock   sync.Mutex
+	errors []string
+}
+
+func (r *recordingTB) Errorf(format string, args ...any) {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+	r.errors = append(r.errors, fmt.Sprintf(format, args...))
+}
+
+func (r *recordingTB) failures() []string {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+	return append([]string{}, r.errors...)
+}
+
+func TestToEvent(t *testing.T) {
+	event := toEvent(events.Message{
+		Type:     events.ContainerEventType,
+		Action:   "health_status: unhealthy",
+		TimeNano: time.Unix(100, 5).UnixNano(),
+		Actor: events.Actor{
+			ID:         "abc",
+			Attributes: map[string]string{"name": "db", "image": "postgres:16"},
+		},
+	})
+	assert.Equal(t, EventHealthStatus, event.Action)
+	assert.Equal(t, "unhealthy", event.Health)
+	assert.Equal(t, "abc", event.ContainerID)
+	assert.Equal(t, "db", event.Name)
+	assert.Equal(t, "postgres:16", event.Image)
+	assert.Equal(t, time.Unix(100, 5), event.Time)
+
+	event = toEvent(events.Message{
+		Action: events.ActionDie,
+		Time:   100,
+		Actor:  events.Actor{Attributes: map[string]string{"exitCode": "137"}},
+	})
+	assert.Equal(t, EventDie, event.Action)
+	assert.Equal(t, "137", event.ExitCode)
+	assert.Equal(t, "", event.Health)
+	assert.Equal(t, time.Unix(100, 0), event.Time)
+}
+
+func TestFailOnDeath(t *testing.T) {
+	crashing, err := NewContainerWithOptions(ContainerOptions{
+		Name:  t.Name() + "-crashing",
+		Image: "alpine",
+		Tag:   "3",
+		Cmd:   []string{"sh", "-c", "sleep 2; exit 3"},
+	})
+	require.Nil(t, err)
+	stopped, err := NewContainerWithOptions(ContainerOptions{
+		Name:  t.Name() + "-stopped",
+		Image: "alpine",
+		Tag:   "3",
+		Cmd:   []string{"sleep", "60"},
+	})
+	require.Nil(t, err)
+
+	require.Nil(t, crashing.Start())
+	defer crashing.Cleanup()
+	require.Nil(t, stopped.Start())
+	defer stopped.Cleanup()
+
+	recorder := &recordingTB{TB: t}
+	ctx := FailOnDeath(recorder, crashing, stopped)
+
+	// Stopping a container on purpose is not a failure
+	require.Nil(t, stopped.Stop(1))
+
+	select {
+	case <-ctx.Done():
+	case <-time.After(30 * time.Second):
+		t.Fatal("the crashing container was never reported")
+	}
+
+	failures := recorder.failures()
+	require.Len(t, failures, 1)
+	assert.Contains(t, failures[0], "-crashing died unexpectedly: exit code 3")
+}
+
+func TestContainerEvents(t *testing.T) {
+	c, err := NewContainerWithOptions(ContainerOptions{
+		Name:  t.Name(),
+		Image: "alpine",
+		Tag:   "3",
+		Cmd:   []string{"sleep", "60"},
+	})
+	require.Nil(t, err)
+	require.Nil(t, c.Start())
+	defer c.Cleanup()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+
+	containerEvents, containerErrs := c.Events(ctx)
+	sessionEvents, sessionErrs := Events(ctx)
+	// Give the subscriptions a moment to be established
+	time.Sleep(500 * time.Millisecond)
+
+	require.Nil(t, c.Kill())
+
+	for _, stream := range []struct {
+		events <-chan Event
+		errs   <-chan error
+	}{{containerEvents, containerErrs}, {sessionEvents, sessionErrs}} {
+		actions := []string{}
+		for len(actions) < 2 {
+			select {
+			case event := <-stream.events:
+				if event.ContainerID == c.GetContainerID() {
+					actions = append(actions, event.Action)
+				}
+			case err := <-stream.errs:
+				t.Fatal(err)
+			}
+		}
+		assert.Equal(t, []string{EventKill, EventDie}, actions)
+	}
+}
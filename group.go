@@ -0,0 +1,91 @@
+package dockerharness
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+)
+
+/*
+StartAll will start every harness concurrently. If any harness fails to
+start, the harnesses that did start are cleaned up and an error joining
+every failure is returned. The context is checked before starting and
+once every Start has returned; an in-progress Start cannot be
+interrupted, so a cancelled context also results in a rollback.
+*/
+func StartAll(ctx context.Context, harnesses ...Harness) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+
+	errs := make([]error, len(harnesses))
+	wg := sync.WaitGroup{}
+	for i, harness := range harnesses {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			if err := harness.Start(); err != nil {
+				errs[i] = fmt.Errorf("failed to start %s: %w", describeHarness(i, harness), err)
+			}
+		}()
+	}
+	wg.Wait()
+
+	failed := errors.Join(errs...)
+	if failed == nil {
+		failed = ctx.Err()
+	}
+	if failed == nil {
+		return nil
+	}
+
+	// Roll back everything that did start
+	started := []Harness{}
+	for i, harness := range harnesses {
+		if errs[i] == nil {
+			started = append(started, harness)
+		}
+	}
+	if err := cleanupHarnesses(started); err != nil {
+		return errors.Join(failed, fmt.Errorf("failed to roll back started harnesses: %w", err))
+	}
+
+	return failed
+}
+
+/*
+CleanupAll will clean up every given harness concurrently. Every harness
+is cleaned up even if others fail, and an error joining every failure is
+returned. Called with no harnesses it does nothing; use
+CleanupRegistered to clean up everything in the global registry.
+*/
+func CleanupAll(harnesses ...Harness) error {
+	return cleanupHarnesses(harnesses)
+}
+
+func cleanupHarnesses(harnesses []Harness) error {
+	errs := make([]error, len(harnesses))
+	wg := sync.WaitGroup{}
+	for i, harness := range harnesses {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			if err := harness.Cleanup(); err != nil {
+				errs[i] = fmt.Errorf("failed to clean up %s: %w", describeHarness(i, harness), err)
+			}
+		}()
+	}
+	wg.Wait()
+
+	return errors.Join(errs...)
+}
+
+// describeHarness names a harness for error messages, using the harness's
+// own name where it has one.
+func describeHarness(index int, harness Harness) string {
+	if named, ok := harness.(interface{ GetName() string }); ok && named.GetName() != "" {
+		return fmt.Sprintf("harness %d (%s)", index, named.GetName())
+	}
+	return fmt.Sprintf("harness %d (%T)", index, harness)
+}
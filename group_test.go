@@ -0,0 +1,110 @@
+package dockerharness
+
+import (
+	"context"
+	"errors"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+type groupTestHarness struct {
+	name       string
+	startErr   error
+	cleanupErr error
+	delay      time.Duration
+	started    atomic.Bool
+	cleaned    atomic.Bool
+}
+
+func (h *groupTestHarness) Start() error {
+	time.Sleep(h.delay)
+	if h.startErr != nil {
+		return h.startErr
+	}
+	h.started.Store(true)
+	return nil
+}
+
+func (h *groupTestHarness) Stop(wait int) error {
+	return nil
+}
+
+func (h *groupTestHarness) Cleanup() error {
+	h.cleaned.Store(true)
+	return h.cleanupErr
+}
+
+func (h *groupTestHarness) IsRunning() (bool, error) {
+	return h.started.Load() && !h.cleaned.Load(), nil
+}
+
+func (h *groupTestHarness) GetName() string {
+	return h.name
+}
+
+func TestStartAll(t *testing.T) {
+	harnesses := []Harness{}
+	for range 4 {
+		harnesses = append(harnesses, &groupTestHarness{delay: 100 * time.Millisecond})
+	}
+
+	// Starting concurrently should take roughly as long as a
+	// single start rather than the sum of all of them
+	start := time.Now()
+	err := StartAll(context.Background(), harnesses...)
+	require.Nil(t, err)
+	assert.Less(t, time.Since(start), 300*time.Millisecond)
+
+	for _, harness := range harnesses {
+		running, err := harness.IsRunning()
+		require.Nil(t, err)
+		assert.True(t, running)
+	}
+}
+
+func TestStartAllRollsBack(t *testing.T) {
+	ok := &groupTestHarness{name: "ok"}
+	broken := &groupTestHarness{name: "broken", startErr: errors.New("boom")}
+	alsoBroken := &groupTestHarness{name: "also-broken", startErr: errors.New("bang")}
+
+	err := StartAll(context.Background(), ok, broken, alsoBroken)
+	require.NotNil(t, err)
+
+	// Every failure is reported, and the harness that did start
+	// is cleaned up
+	assert.ErrorContains(t, err, "broken")
+	assert.ErrorContains(t, err, "boom")
+	assert.ErrorContains(t, err, "bang")
+	assert.True(t, ok.cleaned.Load())
+	assert.False(t, broken.cleaned.Load())
+}
+
+func TestStartAllCancelled(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+
+	harness := &groupTestHarness{}
+	err := StartAll(ctx, harness)
+	assert.ErrorIs(t, err, context.Canceled)
+	assert.False(t, harness.started.Load())
+}
+
+func TestCleanupAll(t *testing.T) {
+	first := &groupTestHarness{cleanupErr: errors.New("first failed")}
+	second := &groupTestHarness{}
+	third := &groupTestHarness{cleanupErr: errors.New("third failed")}
+
+	// A failure must not stop the remaining harnesses from being
+	// cleaned up
+	err := CleanupAll(first, second, third)
+	require.NotNil(t, err)
+	assert.ErrorContains(t, err, "first failed")
+	assert.ErrorContains(t, err, "third failed")
+	assert.True(t, first.cleaned.Load())
+	assert.True(t, second.cleaned.Load())
+	assert.True(t, third.cleaned.Load())
+}
@@ -1,22 +1,36 @@
 package dockerharness
 
 import (
+	"bytes"
 	"context"
+	"errors"
 	"fmt"
 	"io"
 	"net"
 	"os"
+	"path"
+	"path/filepath"
 	"strings"
 	"sync"
+	"sync/atomic"
 	"time"
 
-	"github.com/docker/docker/api/types"
 	"github.com/docker/docker/api/types/container"
+	"github.com/docker/docker/api/types/filters"
 	imgtypes "github.com/docker/docker/api/types/image"
+	"github.com/docker/docker/api/types/mount"
+	"github.com/docker/docker/api/types/network"
 	docker "github.com/docker/docker/client"
+	"github.com/docker/docker/pkg/stdcopy"
 	"github.com/docker/go-connections/nat"
 )
 
+/*
+Harness is anything that can be started and cleaned up as a unit, such as
+a Container, a Compose project, a Stack, or one of the database modules,
+whose Start creates the database. Any of them can be grouped with
+StartAll and CleanupAll.
+*/
 type Harness interface {
 	Start() error
 	Stop(wait int) error
@@ -32,29 +46,208 @@ type Container struct {
 	env     map[string]string
 	image   string
 	tag     string
+	cmd     []string
 	volumes []string
 
+	network string
+	aliases []string
+	mounts  []Mount
+
+	extraHosts []string
+	hostPorts  *HostPorts
+
+	resources   Resources
+	healthcheck *Healthcheck
+
+	waitFor     []WaitStrategy
+	waitHealthy bool
+	waitTimeout time.Duration
+
+	shared    bool
+	sharedKey string
+
+	// stopping is set once the container has been asked to stop, so
+	// that its death is not reported as unexpected
+	stopping atomic.Bool
+
 	lock sync.Mutex
 }
 
+type Mount struct {
+	// Type is "bind", "volume" or "tmpfs"; defaults to "bind"
+	Type string
+	// Source is the host path for bind mounts, or the volume name for
+	// volumes. Relative bind mount paths are relative to the working
+	// directory.
+	Source   string
+	Target   string
+	ReadOnly bool
+}
+
+type ContainerOptions struct {
+	Name  string
+	Image string
+	Tag   string
+	Ports map[string]string
+	Env   map[string]string
+	// Cmd overrides the image's default command if set
+	Cmd []string
+
+	// Network is the name of an existing docker network to attach the
+	// container to, where it can be reached by its name and Aliases.
+	Network string
+	Aliases []string
+
+	// HostGateway maps host.docker.internal to the host, so that the
+	// container can call servers the test runs on the host
+	HostGateway bool
+	// ExtraHosts are additional "hostname:ip" entries for /etc/hosts
+	ExtraHosts []string
+	// HostPorts makes ports exposed with ExposeHostPorts reachable from
+	// the container at host.harness.internal
+	HostPorts *HostPorts
+
+	// Mounts are bind mounts, volumes or tmpfs mounts to attach
+	Mounts []Mount
+
+	// Resources are memory, CPU and other limits to run the container
+	// with
+	Resources Resources
+
+	// Healthcheck replaces the image's own healthcheck, or disables it
+	Healthcheck *Healthcheck
+
+	// WaitFor are checked, in order, once the container has started;
+	// Start does not return until they all pass or WaitTimeout (60
+	// seconds by default) passes.
+	WaitFor []WaitStrategy
+	// WaitHealthy makes Start wait, before any WaitFor, until docker
+	// reports the container healthy, like compose's --wait
+	WaitHealthy bool
+	WaitTimeout time.Duration
+
+	// Shared containers are started once and reused by every process,
+	// such as each package in a `go test ./...` run, that asks for the
+	// same configuration, and removed once the last of them calls
+	// Cleanup. Stop fails with ErrSharedContainerInUse while other
+	// processes hold it. See SharedContainerKey.
+	Shared bool
+}
+
 func NewContainer(name string, image string, tag string, ports map[string]string, env map[string]string) (*Container, error) {
+	return NewContainerWithOptions(ContainerOptions{
+		Name:  name,
+		Image: image,
+		Tag:   tag,
+		Ports: ports,
+		Env:   env,
+	})
+}
+
+/*
+NewContainerWithOptions will create a new container harness with
+additional container options.
+*/
+func NewContainerWithOptions(options ContainerOptions) (*Container, error) {
+	if options.Image == "" {
+		return nil, errors.New("image is required")
+	}
+
 	client, err := docker.NewClientWithOpts(docker.FromEnv)
 	if err != nil {
 		return nil, err
 	}
 
+	tag := options.Tag
 	if tag == "" {
 		tag = "latest"
 	}
 
-	return &Container{
-		client: client,
-		name:   name,
-		image:  image,
-		tag:    tag,
-		ports:  ports,
-		env:    env,
-	}, nil
+	if err := options.Resources.validate(); err != nil {
+		return nil, err
+	}
+	if err := options.Healthcheck.validate(); err != nil {
+		return nil, err
+	}
+	if options.WaitHealthy && options.Healthcheck != nil && options.Healthcheck.Disable {
+		return nil, errors.New("cannot wait for a container to be healthy with its healthcheck disabled")
+	}
+	// The forwarding behind HostPorts belongs to the process that set it
+	// up, so it cannot be handed on to others attaching to the container
+	if options.Shared && options.HostPorts != nil {
+		return nil, errors.New("a shared container cannot use HostPorts")
+	}
+
+	ports := options.Ports
+	if ports == nil {
+		ports = map[string]string{}
+	}
+
+	mounts := make([]Mount, 0, len(options.Mounts))
+	for _, m := range options.Mounts {
+		if m.Type == "" {
+			m.Type = string(mount.TypeBind)
+		}
+		switch mount.Type(m.Type) {
+		case mount.TypeBind:
+			// Docker requires absolute bind mount paths
+			source, err := filepath.Abs(m.Source)
+			if err != nil {
+				return nil, fmt.Errorf("invalid bind mount source %s: %w", m.Source, err)
+			}
+			m.Source = source
+		case mount.TypeVolume, mount.TypeTmpfs:
+		default:
+			return nil, fmt.Errorf("unknown mount type %s", m.Type)
+		}
+		if m.Target == "" {
+			return nil, errors.New("mount target is required")
+		}
+		mounts = append(mounts, m)
+	}
+
+	extraHosts := append([]string{}, options.ExtraHosts...)
+	for _, host := range extraHosts {
+		if name, ip, ok := strings.Cut(host, ":"); !ok || name == "" || ip == "" {
+			return nil, fmt.Errorf("invalid extra host %q; expected \"hostname:ip\"", host)
+		}
+	}
+	if options.HostGateway {
+		extraHosts = append(extraHosts, fmt.Sprintf("%s:host-gateway", HostGatewayName))
+	}
+
+	c := &Container{
+		client:  client,
+		name:    options.Name,
+		image:   options.Image,
+		tag:     tag,
+		ports:   ports,
+		env:     options.Env,
+		cmd:     options.Cmd,
+		network: options.Network,
+		aliases: options.Aliases,
+		mounts:  mounts,
+		shared:  options.Shared,
+
+		extraHosts: extraHosts,
+		hostPorts:  options.HostPorts,
+
+		resources:   options.Resources,
+		healthcheck: options.Healthcheck,
+
+		waitFor:     options.WaitFor,
+		waitHealthy: options.WaitHealthy,
+		waitTimeout: options.WaitTimeout,
+	}
+
+	// Shared containers are named after their configuration so that
+	// every process asking for the same container finds the same one
+	if c.shared {
+		c.sharedKey = SharedContainerKey(options)
+		c.name = fmt.Sprintf("%s%s", sharedContainerPrefix, c.sharedKey[:12])
+	}
+
+	return c, nil
 }
 
 /*
@@ -103,6 +296,55 @@ func (c *Container) Start() error {
 	c.lock.Lock()
 	defer c.lock.Unlock()
 
+	var err error
+	if c.shared {
+		err = c.startShared()
+	} else {
+		err = c.start()
+	}
+
+	// Register anything we created, even if it failed to start, so
+	// that it is not left behind
+	if c.id != "" {
+		Register(c)
+	}
+	if err != nil {
+		return err
+	}
+
+	return c.waitUntilReady()
+}
+
+// waitUntilReady waits for the container to be healthy, if asked to,
+// and then for its wait strategies, all within a single timeout.
+func (c *Container) waitUntilReady() error {
+	if !c.waitHealthy {
+		return waitForAll(c, c.waitFor, c.waitTimeout)
+	}
+
+	timeout := c.waitTimeout
+	if timeout <= 0 {
+		timeout = defaultWaitTimeout
+	}
+	started := time.Now()
+
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+	if err := c.WaitHealthy(ctx); err != nil {
+		return err
+	}
+
+	// The strategies get whatever is left of the timeout
+	remaining := timeout - time.Since(started)
+	if remaining <= 0 {
+		remaining = time.Millisecond
+	}
+	return waitForAll(c, c.waitFor, remaining)
+}
+
+func (c *Container) start() error {
+	c.stopping.Store(false)
+
 	// If the container is already running, return
 	if running, err := c.IsRunning(); err != nil {
 		return err
@@ -113,21 +355,9 @@ func (c *Container) Start() error {
 	// Determine if a container of the same name (but different
 	// id) exists. If so, we need to remove it
 	if c.name != "" {
-		containers, err := c.client.ContainerList(context.Background(), container.ListOptions{
-			All: true,
-		})
-		if err != nil {
+		if err := CleanupAndKillContainer(c.client, c.name); err != nil {
 			return err
 		}
-		for _, container := range containers {
-			if container.Names[0] == fmt.Sprintf("/%s", c.name) {
-				err = CleanupAndKillContainer(c.client, c.name)
-				if err != nil {
-					return err
-				}
-				break
-			}
-		}
 	}
 
 	// Attempt to pull the container if we do not have the
@@ -150,14 +380,7 @@ func (c *Container) Start() error {
 	exposedPorts := nat.PortSet{}
 	for k := range c.ports {
 		// If the port does not contain a protocol, assume tcp
-		var port string
-		if !strings.Contains(k, "/") {
-			port = fmt.Sprintf("%s/tcp", k)
-		} else {
-			port = k
-		}
-
-		exposedPorts[nat.Port(port)] = struct{}{}
+		exposedPorts[nat.Port(normalizePort(k))] = struct{}{}
 	}
 
 	// Determine what ports to assign to the container. If the assigned port is
@@ -166,12 +389,7 @@ func (c *Container) Start() error {
 	portBindings := nat.PortMap{}
 	for k, v := range c.ports {
 		// If the port does not contain a protocol, assume tcp
-		var port string
-		if !strings.Contains(k, "/") {
-			port = fmt.Sprintf("%s/tcp", k)
-		} else {
-			port = k
-		}
+		port := normalizePort(k)
 
 		// If the port is not specified, then assign any free port
 		if v == "" {
@@ -192,21 +410,65 @@ func (c *Container) Start() error {
 		}
 	}
 
+	// Label the container so harness-created resources can be found
+	labels := managedLabels()
+	if c.shared {
+		labels[LabelShared] = c.sharedKey
+	}
+
 	// Create our configs
 	containerConfig := &container.Config{
 		Image:        fmt.Sprintf("%s:%s", c.image, c.tag),
 		Env:          env,
 		ExposedPorts: exposedPorts,
+		Cmd:          c.cmd,
+		Labels:       labels,
+		Healthcheck:  c.healthcheck.config(),
 	}
 	hostConfig := &container.HostConfig{
 		PortBindings: portBindings,
+		ExtraHosts:   append([]string{}, c.extraHosts...),
+	}
+	c.resources.apply(hostConfig)
+	for _, m := range c.mounts {
+		hostConfig.Mounts = append(hostConfig.Mounts, mount.Mount{
+			Type:     mount.Type(m.Type),
+			Source:   m.Source,
+			Target:   m.Target,
+			ReadOnly: m.ReadOnly,
+		})
+	}
+
+	// Containers on a network reach exposed host ports through the
+	// sidecar's alias there; others through its default bridge address
+	if c.hostPorts != nil {
+		if c.network != "" {
+			if err := c.hostPorts.Attach(c.network); err != nil {
+				return err
+			}
+		} else {
+			hostConfig.ExtraHosts = append(hostConfig.ExtraHosts, c.hostPorts.extraHost())
+		}
+	}
+
+	// Attach the container to its network, if any, under its aliases
+	var networkingConfig *network.NetworkingConfig
+	if c.network != "" {
+		hostConfig.NetworkMode = container.NetworkMode(c.network)
+		networkingConfig = &network.NetworkingConfig{
+			EndpointsConfig: map[string]*network.EndpointSettings{
+				c.network: {
+					Aliases: c.aliases,
+				},
+			},
+		}
 	}
 
 	response, err := c.client.ContainerCreate(
 		context.Background(),
 		containerConfig,
 		hostConfig,
-		nil,
+		networkingConfig,
 		nil,
 		c.name,
 	)
@@ -220,24 +482,18 @@ func (c *Container) Start() error {
 		return err
 	}
 
-	// Identify volumes attached to our container
-	list, err := c.client.ContainerList(context.Background(), container.ListOptions{})
+	// Identify volumes attached to our container. We inspect rather
+	// than list running containers so that short lived containers
+	// that have already exited are still found.
+	inspect, err := c.client.ContainerInspect(context.Background(), response.ID)
 	if err != nil {
-		return err
-	}
-	var targetContainer *types.Container
-	for _, container := range list {
-		if container.ID == response.ID {
-			targetContainer = &container
-			break
-		}
-	}
-	if targetContainer == nil {
-		return fmt.Errorf("could not find container when it should be created")
+		return fmt.Errorf("could not find container when it should be created: %w", err)
 	}
 	volumes := []string{}
-	for _, mount := range targetContainer.Mounts {
-		volumes = append(volumes, mount.Name)
+	for _, mount := range inspect.Mounts {
+		if mount.Name != "" {
+			volumes = append(volumes, mount.Name)
+		}
 	}
 	c.volumes = volumes
 
@@ -252,11 +508,21 @@ container.
 
 If a time duration of <= 0 is passed, it is the equivalent of calling
 `.Kill()`
+
+A shared container is only stopped if no other process holds it;
+otherwise ErrSharedContainerInUse is returned.
 */
 func (c *Container) Stop(wait int) error {
 	c.lock.Lock()
 	defer c.lock.Unlock()
 
+	if c.shared && c.id != "" {
+		if err := c.checkSharedStop(); err != nil {
+			return err
+		}
+	}
+	c.stopping.Store(true)
+
 	// If we're not running, we are already stopped
 	if running, err := c.IsRunning(); err != nil {
 		return err
@@ -322,33 +588,206 @@ func (c *Container) Kill() error {
 	return c.Stop(-1)
 }
 
+/*
+Cleanup will kill the container and remove it along with its attached
+volumes. Shared containers are only removed once the last process using
+them has cleaned up; until then Cleanup simply releases this process's
+hold on the container.
+
+Cleanup is safe to call before Start and more than once. Resources that
+are already gone are not an error; every removal is attempted, and all
+failures are returned together.
+*/
 func (c *Container) Cleanup() error {
-	if running, err := c.IsRunning(); err != nil {
+	var err error
+	if c.shared {
+		err = c.releaseShared()
+	} else {
+		err = c.cleanup()
+	}
+	if err != nil {
 		return err
-	} else if running {
-		err := c.Kill()
-		if err != nil {
-			return err
-		}
 	}
+
+	Unregister(c)
+	return nil
+}
+
+func (c *Container) cleanup() error {
 	c.lock.Lock()
 	defer c.lock.Unlock()
 
-	// Remove the container
-	err := c.client.ContainerRemove(context.Background(), c.id, container.RemoveOptions{})
-	if err != nil {
-		return err
+	// Nothing to do if the container was never started, or has been
+	// removed along with all of its volumes
+	if c.id == "" && len(c.volumes) == 0 {
+		return nil
+	}
+
+	errs := []error{}
+
+	// Forcing the removal kills the container if it is still running
+	if c.id != "" {
+		c.stopping.Store(true)
+		err := c.client.ContainerRemove(context.Background(), c.id, container.RemoveOptions{Force: true})
+		if err != nil && !docker.IsErrNotFound(err) {
+			// The volumes are still in use by the container
+			return fmt.Errorf("failed to remove container %s: %w", c.id, err)
+		}
+		c.id = ""
 	}
 
-	// Remove attached volumes
+	// Remove attached volumes, keeping track of any that could not be
+	// removed so that calling Cleanup again retries just those
+	remaining := []string{}
 	for _, volume := range c.volumes {
 		err := c.client.VolumeRemove(context.Background(), volume, true)
-		if err != nil {
-			return err
+		if err != nil && !docker.IsErrNotFound(err) {
+			errs = append(errs, fmt.Errorf("failed to remove volume %s: %w", volume, err))
+			remaining = append(remaining, volume)
 		}
 	}
+	c.volumes = remaining
 
-	return nil
+	return errors.Join(errs...)
+}
+
+/*
+HostAddress will return the host:port address a private port of the
+container is published on, such as "127.0.0.1:49153" for "5432".
+*/
+func (c *Container) HostAddress(ctx context.Context, port string) (string, error) {
+	hostPort, ok := c.ports[port]
+	if !ok {
+		// Allow the port with or without its protocol
+		for k, v := range c.ports {
+			if normalizePort(k) == normalizePort(port) {
+				hostPort, ok = v, true
+				break
+			}
+		}
+	}
+	if !ok || hostPort == "" {
+		return "", fmt.Errorf("port %s is not published", port)
+	}
+
+	return net.JoinHostPort("127.0.0.1", hostPort), nil
+}
+
+/*
+Logs will return the combined stdout and stderr output of the container
+so far.
+*/
+func (c *Container) Logs(ctx context.Context) (string, error) {
+	if c.id == "" {
+		return "", errors.New("container has not been started")
+	}
+
+	out, err := c.client.ContainerLogs(ctx, c.id, container.LogsOptions{
+		ShowStdout: true,
+		ShowStderr: true,
+	})
+	if err != nil {
+		return "", err
+	}
+	defer out.Close()
+
+	logs := &bytes.Buffer{}
+	if _, err := stdcopy.StdCopy(logs, logs, out); err != nil {
+		return "", err
+	}
+
+	return logs.String(), nil
+}
+
+/*
+Exec will run a command inside the running container and return its
+exit code and output. A non-zero exit code is not an error.
+*/
+func (c *Container) Exec(ctx context.Context, cmd []string) (ExecResult, error) {
+	if c.id == "" {
+		return ExecResult{}, errors.New("container has not been started")
+	}
+
+	exec, err := c.client.ContainerExecCreate(ctx, c.id, container.ExecOptions{
+		Cmd:          cmd,
+		AttachStdout: true,
+		AttachStderr: true,
+	})
+	if err != nil {
+		return ExecResult{}, err
+	}
+
+	attach, err := c.client.ContainerExecAttach(ctx, exec.ID, container.ExecAttachOptions{})
+	if err != nil {
+		return ExecResult{}, err
+	}
+	defer attach.Close()
+
+	stdout := &bytes.Buffer{}
+	stderr := &bytes.Buffer{}
+	if _, err := stdcopy.StdCopy(stdout, stderr, attach.Reader); err != nil {
+		return ExecResult{}, err
+	}
+
+	inspect, err := c.client.ContainerExecInspect(ctx, exec.ID)
+	if err != nil {
+		return ExecResult{}, err
+	}
+
+	return ExecResult{
+		ExitCode: inspect.ExitCode,
+		Stdout:   stdout.String(),
+		Stderr:   stderr.String(),
+	}, nil
+}
+
+/*
+ExportEnv will publish where each of the container's ports can be
+reached, named after the container - or its image if it has no name -
+such as NGINX_HOST, NGINX_PORT_80 and NGINX_ADDR_80.
+*/
+func (c *Container) ExportEnv() (map[string]string, error) {
+	name := c.name
+	if name == "" {
+		name = path.Base(c.image)
+	}
+	return c.exportEnv(envName(name))
+}
+
+// exportEnv publishes the container's host and ports under the prefix.
+func (c *Container) exportEnv(prefix string) (map[string]string, error) {
+	if c.id == "" {
+		return nil, errors.New("container has not been started")
+	}
+
+	env := map[string]string{
+		fmt.Sprintf("%s_HOST", prefix): "127.0.0.1",
+	}
+	for _, port := range sortedKeys(c.ports) {
+		if c.ports[port] == "" {
+			continue
+		}
+		name := portEnvName(port)
+		env[fmt.Sprintf("%s_PORT_%s", prefix, name)] = c.ports[port]
+		env[fmt.Sprintf("%s_ADDR_%s", prefix, name)] = net.JoinHostPort("127.0.0.1", c.ports[port])
+	}
+	return env, nil
+}
+
+// describe names the container for messages, by its name if it has one
+// or otherwise its short id.
+func (c *Container) describe() string {
+	if c.name != "" {
+		return c.name
+	}
+	if len(c.id) > 12 {
+		return c.id[:12]
+	}
+	return c.id
+}
+
+func (c *Container) GetName() string {
+	return c.name
 }
 
 func (c *Container) GetContainerID() string {
@@ -420,47 +859,54 @@ Given a container of a given name, this function will kill and cleanup
 all volumes associated with that container.
 */
 func CleanupAndKillContainer(client *docker.Client, name string) error {
-	// Get the container's ID
-	volumes := []string{}
-	containers, err := client.ContainerList(context.Background(), container.ListOptions{})
+	// Find the container, whether it is running or not
+	containers, err := client.ContainerList(context.Background(), container.ListOptions{
+		All:     true,
+		Filters: filters.NewArgs(filters.Arg("name", name)),
+	})
 	if err != nil {
 		return err
 	}
-	var containerID string
-	for _, container := range containers {
-		if container.Names[0] == fmt.Sprintf("/%s", name) {
-			containerID = container.ID
-			for _, volume := range container.Mounts {
-				volumes = append(volumes, volume.Name)
+
+	// The name filter matches on substrings, so look for an exact match
+	var found *container.Summary
+	for i, c := range containers {
+		for _, n := range c.Names {
+			if n == fmt.Sprintf("/%s", name) {
+				found = &containers[i]
 			}
-			break
 		}
 	}
-	if containerID == "" {
+	if found == nil {
 		return nil
 	}
 
-	// Attempt to stop the container
-	err = client.ContainerKill(context.Background(), name, "SIGKILL")
-	if err != nil {
-		return err
-	}
-
-	// Remove the container
-	err = client.ContainerRemove(context.Background(), name, container.RemoveOptions{})
-	if err != nil {
+	// Forcing the removal kills the container if it is still running
+	err = client.ContainerRemove(context.Background(), found.ID, container.RemoveOptions{Force: true})
+	if err != nil && !docker.IsErrNotFound(err) {
 		return err
 	}
 
-	// Remove containers
-	for _, volume := range volumes {
-		err := client.VolumeRemove(context.Background(), volume, true)
-		if err != nil {
-			return err
+	errs := []error{}
+	for _, mount := range found.Mounts {
+		if mount.Name == "" {
+			continue
+		}
+		err := client.VolumeRemove(context.Background(), mount.Name, true)
+		if err != nil && !docker.IsErrNotFound(err) {
+			errs = append(errs, fmt.Errorf("failed to remove volume %s: %w", mount.Name, err))
 		}
 	}
 
-	return nil
+	return errors.Join(errs...)
+}
+
+// normalizePort adds the default tcp protocol to a port without one.
+func normalizePort(port string) string {
+	if !strings.Contains(port, "/") {
+		return fmt.Sprintf("%s/tcp", port)
+	}
+	return port
 }
 
 // getFreePort finds an available port by listening on port 0, which
@@ -5,9 +5,11 @@ import (
 	"fmt"
 	"math/rand/v2"
 	"testing"
+	"time"
 
 	containerTypes "github.com/docker/docker/api/types/container"
 	"github.com/docker/docker/api/types/volume"
+	docker "github.com/docker/docker/client"
 	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/require"
 )
@@ -302,3 +304,62 @@ func TestPortMapping(t *testing.T) {
 	assert.Equal(t, ports["3306"], inspect.NetworkSettings.Ports["3306/tcp"][0].HostPort)
 	assert.Equal(t, ports["3307"], inspect.NetworkSettings.Ports["3307/tcp"][0].HostPort)
 }
+
+func TestCleanupBeforeStart(t *testing.T) {
+	container, err := NewContainer(t.Name(), "alpine", "3", map[string]string{}, map[string]string{})
+	require.Nil(t, err)
+
+	// Nothing was started, so there is nothing to remove
+	require.Nil(t, container.Cleanup())
+	require.Nil(t, container.Cleanup())
+}
+
+func TestCleanupIsIdempotent(t *testing.T) {
+	container, err := NewContainerWithOptions(ContainerOptions{
+		Name:   t.Name(),
+		Image:  "alpine",
+		Tag:    "3",
+		Cmd:    []string{"sleep", "60"},
+		Mounts: []Mount{{Type: "volume", Target: "/data"}},
+	})
+	require.Nil(t, err)
+
+	require.Nil(t, container.Start())
+	require.NotEmpty(t, container.GetContainerID())
+	require.Nil(t, container.Cleanup())
+	assert.Empty(t, container.GetContainerID())
+
+	// The container and its volume are already gone
+	require.Nil(t, container.Cleanup())
+
+	// ...even when removed behind the harness's back
+	require.Nil(t, container.Start())
+	err = container.client.ContainerRemove(context.Background(), container.GetContainerID(), containerTypes.RemoveOptions{Force: true})
+	require.Nil(t, err)
+	require.Nil(t, container.Cleanup())
+}
+
+func TestCleanupAndKillStoppedContainer(t *testing.T) {
+	container, err := NewContainerWithOptions(ContainerOptions{
+		Name:  t.Name(),
+		Image: "alpine",
+		Tag:   "3",
+		Cmd:   []string{"true"},
+	})
+	require.Nil(t, err)
+	require.Nil(t, container.Start())
+	defer container.Cleanup()
+
+	// Wait for the container to exit on its own
+	for range 50 {
+		if running, err := container.IsRunning(); err == nil && !running {
+			break
+		}
+		time.Sleep(100 * time.Millisecond)
+	}
+
+	// A stopped container of the same name is still found and removed
+	require.Nil(t, CleanupAndKillContainer(container.client, t.Name()))
+	_, err = container.client.ContainerInspect(context.Background(), container.id)
+	assert.True(t, docker.IsErrNotFound(err))
+}
@@ -0,0 +1,127 @@
+package dockerharness
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/docker/docker/api/types/container"
+)
+
+/*
+Healthcheck defines how docker checks that a container is healthy,
+overriding any HEALTHCHECK the image ships with. Zero durations and
+retries use docker's defaults.
+*/
+type Healthcheck struct {
+	// Test is the command to run inside the container, such as
+	// []string{"pg_isready", "-U", "postgres"}. Start it with
+	// "CMD-SHELL" to run a single string through the shell instead.
+	Test []string
+	// Interval is the time between checks; StartInterval is the time
+	// between checks during the StartPeriod, when failures do not
+	// count towards Retries
+	Interval      time.Duration
+	Timeout       time.Duration
+	StartPeriod   time.Duration
+	StartInterval time.Duration
+	// Retries is how many consecutive failures mark the container
+	// unhealthy
+	Retries int
+
+	// Disable turns off the image's own healthcheck; Test is ignored
+	Disable bool
+}
+
+// validate checks the healthcheck for mistakes docker would only report
+// once the container is created.
+func (h *Healthcheck) validate() error {
+	if h == nil || h.Disable {
+		return nil
+	}
+	if len(h.Test) == 0 {
+		return errors.New("healthcheck test is required")
+	}
+	if h.Test[0] == "CMD-SHELL" && len(h.Test) != 2 {
+		return errors.New("a CMD-SHELL healthcheck takes a single command string")
+	}
+	if h.Interval < 0 || h.Timeout < 0 || h.StartPeriod < 0 || h.StartInterval < 0 || h.Retries < 0 {
+		return errors.New("healthcheck durations and retries cannot be negative")
+	}
+	return nil
+}
+
+// config converts the healthcheck into docker's container config.
+func (h *Healthcheck) config() *container.HealthConfig {
+	if h == nil {
+		return nil
+	}
+	if h.Disable {
+		return &container.HealthConfig{Test: []string{"NONE"}}
+	}
+
+	test := h.Test
+	if test[0] != "CMD" && test[0] != "CMD-SHELL" {
+		test = append([]string{"CMD"}, test...)
+	}
+
+	return &container.HealthConfig{
+		Test:          test,
+		Interval:      h.Interval,
+		Timeout:       h.Timeout,
+		StartPeriod:   h.StartPeriod,
+		StartInterval: h.StartInterval,
+		Retries:       h.Retries,
+	}
+}
+
+/*
+WaitHealthy will block until docker reports the container as healthy.
+It fails as soon as the container is reported unhealthy or exits, or
+when the context is done, including the output of the last healthcheck
+in the error.
+*/
+func (c *Container) WaitHealthy(ctx context.Context) error {
+	if c.id == "" {
+		return errors.New("container has not been started")
+	}
+
+	for {
+		inspect, err := c.client.ContainerInspect(ctx, c.id)
+		if err != nil {
+			return err
+		}
+		if inspect.State == nil || inspect.State.Health == nil {
+			return fmt.Errorf("container %s has no healthcheck", c.describe())
+		}
+
+		health := inspect.State.Health
+		switch {
+		case health.Status == container.Healthy:
+			return nil
+		case health.Status == container.Unhealthy:
+			return fmt.Errorf("container %s is unhealthy%s", c.describe(), lastHealthcheck(health))
+		case !inspect.State.Running:
+			return fmt.Errorf("container %s exited with code %d before becoming healthy%s", c.describe(), inspect.State.ExitCode, lastHealthcheck(health))
+		}
+
+		select {
+		case <-ctx.Done():
+			return fmt.Errorf("%w: container %s did not become healthy%s", ctx.Err(), c.describe(), lastHealthcheck(health))
+		case <-time.After(defaultWaitInterval):
+		}
+	}
+}
+
+// lastHealthcheck describes the most recent healthcheck result for an
+// error message.
+func lastHealthcheck(health *container.Health) string {
+	if len(health.Log) == 0 || health.Log[len(health.Log)-1] == nil {
+		return ""
+	}
+
+	last := health.Log[len(health.Log)-1]
+	return fmt.Sprintf("; last healthcheck exited with code %d: %s", last.ExitCode, strings.TrimSpace(last.Output))
+}
@@ -0,0 +1,116 @@
+package dockerharness
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/docker/docker/api/types/container"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestHealthcheckConfig(t *testing.T) {
+	// Without a healthcheck the image's own is kept
+	var none *Healthcheck
+	require.Nil(t, none.validate())
+	assert.Nil(t, none.config())
+
+	// Commands run directly unless they ask for the shell
+	healthcheck := &Healthcheck{
+		Test:     []string{"pg_isready", "-U", "postgres"},
+		Interval: time.Second,
+		Retries:  5,
+	}
+	require.Nil(t, healthcheck.validate())
+	assert.Equal(t, &container.HealthConfig{
+		Test:     []string{"CMD", "pg_isready", "-U", "postgres"},
+		Interval: time.Second,
+		Retries:  5,
+	}, healthcheck.config())
+
+	healthcheck = &Healthcheck{Test: []string{"CMD-SHELL", "redis-cli ping | grep PONG"}}
+	require.Nil(t, healthcheck.validate())
+	assert.Equal(t, []string{"CMD-SHELL", "redis-cli ping | grep PONG"}, healthcheck.config().Test)
+
+	// Disabling ignores any test
+	healthcheck = &Healthcheck{Disable: true, Test: []string{"true"}}
+	require.Nil(t, healthcheck.validate())
+	assert.Equal(t, &container.HealthConfig{Test: []string{"NONE"}}, healthcheck.config())
+
+	assert.NotNil(t, (&Healthcheck{}).validate())
+	assert.NotNil(t, (&Healthcheck{Test: []string{"CMD-SHELL", "a", "b"}}).validate())
+	assert.NotNil(t, (&Healthcheck{Test: []string{"true"}, Retries: -1}).validate())
+
+	// There is nothing to wait for with the healthcheck disabled
+	_, err := NewContainerWithOptions(ContainerOptions{
+		Image:       "alpine",
+		Healthcheck: &Healthcheck{Disable: true},
+		WaitHealthy: true,
+	})
+	require.NotNil(t, err)
+}
+
+func TestLastHealthcheck(t *testing.T) {
+	assert.Equal(t, "", lastHealthcheck(&container.Health{}))
+	assert.Equal(t, "; last healthcheck exited with code 1: connection refused", lastHealthcheck(&container.Health{
+		Log: []*container.HealthcheckResult{
+			{ExitCode: 0, Output: "ok"},
+			{ExitCode: 1, Output: "connection refused\n"},
+		},
+	}))
+}
+
+func TestContainerWaitHealthy(t *testing.T) {
+	c, err := NewContainerWithOptions(ContainerOptions{
+		Name:  t.Name(),
+		Image: "alpine",
+		Tag:   "3",
+		// Healthy once the file appears a couple of seconds in
+		Cmd: []string{"sh", "-c", "sleep 2 && touch /tmp/ready && sleep 60"},
+		Healthcheck: &Healthcheck{
+			Test:     []string{"test", "-f", "/tmp/ready"},
+			Interval: 500 * time.Millisecond,
+			Retries:  20,
+		},
+		WaitHealthy: true,
+		WaitTimeout: 30 * time.Second,
+	})
+	require.Nil(t, err)
+
+	err = c.Start()
+	require.Nil(t, err)
+	defer c.Cleanup()
+
+	state, err := c.State()
+	require.Nil(t, err)
+	assert.Equal(t, "healthy", state.Health)
+}
+
+func TestContainerWaitUnhealthy(t *testing.T) {
+	c, err := NewContainerWithOptions(ContainerOptions{
+		Name:  t.Name(),
+		Image: "alpine",
+		Tag:   "3",
+		Cmd:   []string{"sleep", "60"},
+		Healthcheck: &Healthcheck{
+			Test:     []string{"CMD-SHELL", "echo not ready && false"},
+			Interval: 500 * time.Millisecond,
+			Retries:  2,
+		},
+	})
+	require.Nil(t, err)
+
+	err = c.Start()
+	require.Nil(t, err)
+	defer c.Cleanup()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+
+	// The failure includes what the healthcheck printed
+	err = c.WaitHealthy(ctx)
+	require.NotNil(t, err)
+	assert.Contains(t, err.Error(), "is unhealthy")
+	assert.Contains(t, err.Error(), "not ready")
+}
@@ -0,0 +1,339 @@
+package dockerharness
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/docker/docker/api/types/network"
+	docker "github.com/docker/docker/client"
+	"golang.org/x/crypto/ssh"
+)
+
+const (
+	// HostGatewayName is the name ContainerOptions.HostGateway maps to
+	// the host, matching Docker Desktop.
+	HostGatewayName = "host.docker.internal"
+	// HostPortsName is the name containers reach ports exposed with
+	// ExposeHostPorts on.
+	HostPortsName = "host.harness.internal"
+
+	hostPortsImage = "testcontainers/sshd"
+	hostPortsTag   = "1.2.0"
+)
+
+/*
+HostPorts makes ports on the host reachable from containers, such as an
+httptest server a container needs to call back into. It works where
+host-gateway is not routable by running a small SSH sidecar: the
+harness connects to it through a published port and asks it to forward
+connections back over that connection. Containers reach the ports on
+HostPortsName.
+*/
+type HostPorts struct {
+	sidecar  *Container
+	password string
+	ports    []int
+
+	client    *ssh.Client
+	listeners []net.Listener
+	// bridgeIP is the sidecar's address on the default bridge network,
+	// for containers that are not on a user-defined network
+	bridgeIP string
+	networks map[string]bool
+
+	lock sync.Mutex
+	wg   sync.WaitGroup
+}
+
+var _ Harness = (*HostPorts)(nil)
+
+/*
+ExposeHostPorts will start a sidecar making the given host ports
+reachable from containers at HostPortsName. Pass it to containers and
+stacks through their HostPorts option, or attach it to other networks
+with Attach. Clean it up with Cleanup once it is no longer needed.
+*/
+func ExposeHostPorts(ports ...int) (*HostPorts, error) {
+	if len(ports) == 0 {
+		return nil, errors.New("at least one port is required")
+	}
+	for _, port := range ports {
+		if port <= 0 || port > 65535 {
+			return nil, fmt.Errorf("invalid port %d", port)
+		}
+	}
+
+	secret := make([]byte, 16)
+	if _, err := rand.Read(secret); err != nil {
+		return nil, err
+	}
+	password := hex.EncodeToString(secret)
+
+	sidecar, err := NewContainerWithOptions(ContainerOptions{
+		Image: hostPortsImage,
+		Tag:   hostPortsTag,
+		Ports: map[string]string{"22": ""},
+		Env:   map[string]string{"PASSWORD": password},
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	h := &HostPorts{
+		sidecar:  sidecar,
+		password: password,
+		ports:    ports,
+		networks: map[string]bool{},
+	}
+	if err := h.Start(); err != nil {
+		return nil, errors.Join(err, h.Cleanup())
+	}
+
+	return h, nil
+}
+
+/*
+Start will start the sidecar and begin forwarding the ports. It is
+called by ExposeHostPorts, and does nothing if already started.
+*/
+func (h *HostPorts) Start() error {
+	h.lock.Lock()
+	defer h.lock.Unlock()
+
+	if h.client != nil {
+		return nil
+	}
+
+	if err := h.sidecar.Start(); err != nil {
+		return err
+	}
+	// The sidecar is owned by, and cleaned up with, the HostPorts
+	Unregister(h.sidecar)
+	Register(h)
+	h.networks = map[string]bool{}
+
+	address, err := h.sidecar.HostAddress(context.Background(), "22")
+	if err != nil {
+		return err
+	}
+
+	config := &ssh.ClientConfig{
+		User:            "root",
+		Auth:            []ssh.AuthMethod{ssh.Password(h.password)},
+		HostKeyCallback: ssh.InsecureIgnoreHostKey(),
+		Timeout:         5 * time.Second,
+	}
+
+	// sshd takes a moment to accept connections once started
+	ctx, cancel := context.WithTimeout(context.Background(), defaultWaitTimeout)
+	defer cancel()
+	var client *ssh.Client
+	err = poll(ctx, func() error {
+		client, err = ssh.Dial("tcp", address, config)
+		return err
+	})
+	if err != nil {
+		return fmt.Errorf("failed to connect to host ports sidecar: %w", err)
+	}
+
+	// The client is only kept once forwarding is fully set up, so that
+	// a failed Start can be retried
+	if err := h.connect(client); err != nil {
+		for _, listener := range h.listeners {
+			listener.Close()
+		}
+		h.listeners = nil
+		client.Close()
+		h.wg.Wait()
+		return err
+	}
+	h.client = client
+
+	return nil
+}
+
+// connect forwards the ports over the client and finds the sidecar's
+// address for containers on the default bridge network.
+func (h *HostPorts) connect(client *ssh.Client) error {
+	for _, port := range h.ports {
+		listener, err := client.Listen("tcp", net.JoinHostPort("0.0.0.0", strconv.Itoa(port)))
+		if err != nil {
+			return fmt.Errorf("failed to forward host port %d: %w", port, err)
+		}
+		h.listeners = append(h.listeners, listener)
+
+		h.wg.Add(1)
+		go h.forward(listener, port)
+	}
+
+	info, err := h.sidecar.Inspect(context.Background())
+	if err != nil {
+		return err
+	}
+	bridgeIP := info.Networks[network.NetworkBridge].IPAddress
+	if bridgeIP == "" {
+		return errors.New("host ports sidecar has no address on the default bridge network")
+	}
+	h.bridgeIP = bridgeIP
+
+	return nil
+}
+
+// forward accepts connections made to the port inside the sidecar and
+// pipes each to the same port on the host.
+func (h *HostPorts) forward(listener net.Listener, port int) {
+	defer h.wg.Done()
+
+	for {
+		remote, err := listener.Accept()
+		if err != nil {
+			// The listener is closed on cleanup
+			return
+		}
+
+		go func() {
+			defer remote.Close()
+
+			local, err := net.DialTimeout("tcp", net.JoinHostPort("127.0.0.1", strconv.Itoa(port)), 5*time.Second)
+			if err != nil {
+				return
+			}
+			defer local.Close()
+
+			done := make(chan struct{}, 2)
+			go func() {
+				io.Copy(local, remote)
+				done <- struct{}{}
+			}()
+			go func() {
+				io.Copy(remote, local)
+				done <- struct{}{}
+			}()
+			<-done
+		}()
+	}
+}
+
+/*
+Attach will connect the sidecar to a network, where containers can
+reach the exposed ports at HostPortsName.
+*/
+func (h *HostPorts) Attach(networkName string) error {
+	h.lock.Lock()
+	defer h.lock.Unlock()
+
+	if h.networks[networkName] {
+		return nil
+	}
+
+	err := h.sidecar.client.NetworkConnect(context.Background(), networkName, h.sidecar.GetContainerID(), &network.EndpointSettings{
+		Aliases: []string{HostPortsName},
+	})
+	if err != nil {
+		return fmt.Errorf("failed to attach host ports to network %s: %w", networkName, err)
+	}
+	h.networks[networkName] = true
+
+	return nil
+}
+
+/*
+Detach will disconnect the sidecar from a network it was attached to,
+such as before the network is removed.
+*/
+func (h *HostPorts) Detach(networkName string) error {
+	h.lock.Lock()
+	defer h.lock.Unlock()
+
+	if !h.networks[networkName] {
+		return nil
+	}
+
+	err := h.sidecar.client.NetworkDisconnect(context.Background(), networkName, h.sidecar.GetContainerID(), true)
+	if err != nil && !docker.IsErrNotFound(err) {
+		return fmt.Errorf("failed to detach host ports from network %s: %w", networkName, err)
+	}
+	delete(h.networks, networkName)
+
+	return nil
+}
+
+/*
+Address returns the address containers reach an exposed host port on,
+such as "host.harness.internal:8080".
+*/
+func (h *HostPorts) Address(port int) string {
+	return net.JoinHostPort(HostPortsName, strconv.Itoa(port))
+}
+
+func (h *HostPorts) GetPorts() []int {
+	return append([]int{}, h.ports...)
+}
+
+/*
+Stop will stop forwarding the ports and stop the sidecar.
+*/
+func (h *HostPorts) Stop(wait int) error {
+	h.lock.Lock()
+	defer h.lock.Unlock()
+
+	h.disconnect()
+	return h.sidecar.Stop(wait)
+}
+
+/*
+Cleanup will stop forwarding the ports and remove the sidecar. It is
+safe to call more than once.
+*/
+func (h *HostPorts) Cleanup() error {
+	h.lock.Lock()
+	defer h.lock.Unlock()
+
+	h.disconnect()
+	if err := h.sidecar.Cleanup(); err != nil {
+		return err
+	}
+	h.networks = map[string]bool{}
+
+	Unregister(h)
+	return nil
+}
+
+func (h *HostPorts) IsRunning() (bool, error) {
+	return h.sidecar.IsRunning()
+}
+
+func (h *HostPorts) GetName() string {
+	return fmt.Sprintf("host ports %v", h.ports)
+}
+
+// disconnect closes the forwarding listeners and the SSH connection,
+// waiting for the forwarding goroutines to exit.
+func (h *HostPorts) disconnect() {
+	for _, listener := range h.listeners {
+		listener.Close()
+	}
+	h.listeners = nil
+	if h.client != nil {
+		h.client.Close()
+		h.client = nil
+	}
+	h.wg.Wait()
+}
+
+// extraHost returns the /etc/hosts entry that points HostPortsName at
+// the sidecar for containers on the default bridge network.
+func (h *HostPorts) extraHost() string {
+	h.lock.Lock()
+	defer h.lock.Unlock()
+
+	return fmt.Sprintf("%s:%s", HostPortsName, h.bridgeIP)
+}
@@ -0,0 +1,98 @@
+package dockerharness
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestExtraHostsOptions(t *testing.T) {
+	c, err := NewContainerWithOptions(ContainerOptions{
+		Image:       "alpine",
+		ExtraHosts:  []string{"api.internal:10.0.0.5"},
+		HostGateway: true,
+	})
+	require.Nil(t, err)
+	assert.Equal(t, []string{"api.internal:10.0.0.5", "host.docker.internal:host-gateway"}, c.extraHosts)
+
+	_, err = NewContainerWithOptions(ContainerOptions{
+		Image:      "alpine",
+		ExtraHosts: []string{"missing-ip"},
+	})
+	require.NotNil(t, err)
+
+	_, err = ExposeHostPorts()
+	require.NotNil(t, err)
+	_, err = ExposeHostPorts(70000)
+	require.NotNil(t, err)
+}
+
+func TestExposeHostPorts(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprint(w, "called back")
+	}))
+	defer server.Close()
+
+	_, portString, err := net.SplitHostPort(strings.TrimPrefix(server.URL, "http://"))
+	require.Nil(t, err)
+	port, err := strconv.Atoi(portString)
+	require.Nil(t, err)
+
+	hostPorts, err := ExposeHostPorts(port)
+	require.Nil(t, err)
+	defer hostPorts.Cleanup()
+	assert.Equal(t, fmt.Sprintf("host.harness.internal:%d", port), hostPorts.Address(port))
+
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+	url := fmt.Sprintf("http://%s/", hostPorts.Address(port))
+
+	// A container on the default bridge network
+	bridged, err := NewContainerWithOptions(ContainerOptions{
+		Name:      t.Name() + "-bridged",
+		Image:     "alpine",
+		Tag:       "3",
+		Cmd:       []string{"sleep", "60"},
+		HostPorts: hostPorts,
+	})
+	require.Nil(t, err)
+	require.Nil(t, bridged.Start())
+	defer bridged.Cleanup()
+
+	result, err := bridged.Exec(ctx, []string{"wget", "-qO-", url})
+	require.Nil(t, err)
+	assert.Equal(t, 0, result.ExitCode, result.Stderr)
+	assert.Equal(t, "called back", result.Stdout)
+
+	// A container in a stack, which reaches the sidecar on the stack's
+	// network
+	stacked, err := NewContainerWithOptions(ContainerOptions{
+		Image: "alpine",
+		Tag:   "3",
+		Cmd:   []string{"sleep", "60"},
+	})
+	require.Nil(t, err)
+	stack, err := NewStack(StackOptions{
+		Containers: map[string]StackContainer{"app": {Container: stacked}},
+		HostPorts:  hostPorts,
+	})
+	require.Nil(t, err)
+	require.Nil(t, stack.Start())
+
+	result, err = stacked.Exec(ctx, []string{"wget", "-qO-", url})
+	require.Nil(t, err)
+	assert.Equal(t, 0, result.ExitCode, result.Stderr)
+	assert.Equal(t, "called back", result.Stdout)
+
+	// The sidecar leaves the stack network so that it can be removed
+	require.Nil(t, stack.Cleanup())
+}
@@ -0,0 +1,234 @@
+package dockerharness
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/docker/docker/api/types/container"
+)
+
+/*
+ContainerInfo is a snapshot of a container's configuration and runtime
+state, as returned by Inspect.
+*/
+type ContainerInfo struct {
+	ID    string
+	Name  string
+	Image string
+	State ContainerState
+	// Networks maps each network the container is attached to onto its
+	// address within it
+	Networks map[string]ContainerNetwork
+	Mounts   []Mount
+	// Ports maps each private port to the host port it is published on
+	Ports map[string]string
+}
+
+type ContainerNetwork struct {
+	IPAddress string
+	Gateway   string
+	Aliases   []string
+}
+
+/*
+Inspect will return a snapshot of the container's status, health, exit
+code, start and finish times, restart count, network addresses and
+mounts.
+*/
+func (c *Container) Inspect(ctx context.Context) (ContainerInfo, error) {
+	if c.id == "" {
+		return ContainerInfo{}, errors.New("container has not been started")
+	}
+
+	inspect, err := c.client.ContainerInspect(ctx, c.id)
+	if err != nil {
+		return ContainerInfo{}, err
+	}
+
+	state, err := containerState(inspect)
+	if err != nil {
+		return ContainerInfo{}, err
+	}
+
+	info := ContainerInfo{
+		ID:       inspect.ID,
+		Name:     strings.TrimPrefix(inspect.Name, "/"),
+		State:    state,
+		Networks: map[string]ContainerNetwork{},
+		Mounts:   []Mount{},
+		Ports:    map[string]string{},
+	}
+	if inspect.Config != nil {
+		info.Image = inspect.Config.Image
+	}
+
+	if inspect.NetworkSettings != nil {
+		for name, endpoint := range inspect.NetworkSettings.Networks {
+			if endpoint == nil {
+				continue
+			}
+			info.Networks[name] = ContainerNetwork{
+				IPAddress: endpoint.IPAddress,
+				Gateway:   endpoint.Gateway,
+				Aliases:   endpoint.Aliases,
+			}
+		}
+		for port, bindings := range inspect.NetworkSettings.Ports {
+			if len(bindings) > 0 {
+				info.Ports[string(port)] = bindings[0].HostPort
+			}
+		}
+	}
+
+	for _, m := range inspect.Mounts {
+		source := m.Source
+		if m.Name != "" {
+			source = m.Name
+		}
+		info.Mounts = append(info.Mounts, Mount{
+			Type:     string(m.Type),
+			Source:   source,
+			Target:   m.Destination,
+			ReadOnly: !m.RW,
+		})
+	}
+
+	return info, nil
+}
+
+/*
+ContainerStats is a single sample of a container's resource usage.
+*/
+type ContainerStats struct {
+	Time time.Time
+	// CPUPercent is the share of the host's CPUs used since the last
+	// sample, where 100% is one CPU fully used, as `docker stats` shows
+	CPUPercent float64
+	// MemoryUsage excludes the page cache, as `docker stats` does
+	MemoryUsage   uint64
+	MemoryLimit   uint64
+	MemoryPercent float64
+	NetworkRx     uint64
+	NetworkTx     uint64
+	BlockRead     uint64
+	BlockWrite    uint64
+	PIDs          uint64
+}
+
+/*
+StatsOnce will return a single sample of the container's resource usage.
+*/
+func (c *Container) StatsOnce(ctx context.Context) (ContainerStats, error) {
+	if c.id == "" {
+		return ContainerStats{}, errors.New("container has not been started")
+	}
+
+	response, err := c.client.ContainerStats(ctx, c.id, false)
+	if err != nil {
+		return ContainerStats{}, err
+	}
+	defer response.Body.Close()
+
+	stats := container.StatsResponse{}
+	if err := json.NewDecoder(response.Body).Decode(&stats); err != nil {
+		return ContainerStats{}, fmt.Errorf("failed to read stats: %w", err)
+	}
+
+	return containerStats(stats), nil
+}
+
+/*
+Stats will sample the container's resource usage roughly once a second
+until the context is done or the container stops, at which point the
+channel is closed.
+*/
+func (c *Container) Stats(ctx context.Context) (<-chan ContainerStats, error) {
+	if c.id == "" {
+		return nil, errors.New("container has not been started")
+	}
+
+	response, err := c.client.ContainerStats(ctx, c.id, true)
+	if err != nil {
+		return nil, err
+	}
+
+	samples := make(chan ContainerStats)
+	go func() {
+		defer close(samples)
+		defer response.Body.Close()
+
+		decoder := json.NewDecoder(response.Body)
+		for {
+			stats := container.StatsResponse{}
+			// The stream ends once the container stops or the context
+			// is done
+			if err := decoder.Decode(&stats); err != nil {
+				return
+			}
+
+			select {
+			case samples <- containerStats(stats):
+			case <-ctx.Done():
+				return
+			}
+		}
+	}()
+
+	return samples, nil
+}
+
+// containerStats converts docker's raw stats into a sample, calculating
+// usage the same way the docker CLI does.
+func containerStats(stats container.StatsResponse) ContainerStats {
+	sample := ContainerStats{
+		Time:        stats.Read,
+		MemoryLimit: stats.MemoryStats.Limit,
+		PIDs:        stats.PidsStats.Current,
+	}
+
+	// CPU usage is the container's share of the system's CPU time since
+	// the previous sample, scaled by the number of CPUs
+	cpuDelta := float64(stats.CPUStats.CPUUsage.TotalUsage) - float64(stats.PreCPUStats.CPUUsage.TotalUsage)
+	systemDelta := float64(stats.CPUStats.SystemUsage) - float64(stats.PreCPUStats.SystemUsage)
+	cpus := float64(stats.CPUStats.OnlineCPUs)
+	if cpus == 0 {
+		cpus = float64(len(stats.CPUStats.CPUUsage.PercpuUsage))
+	}
+	if cpuDelta > 0 && systemDelta > 0 {
+		sample.CPUPercent = cpuDelta / systemDelta * cpus * 100
+	}
+
+	// Page cache can be reclaimed, so it does not count towards usage;
+	// cgroup v1 reports it as total_inactive_file and v2 inactive_file
+	cache := stats.MemoryStats.Stats["total_inactive_file"]
+	if cache == 0 {
+		cache = stats.MemoryStats.Stats["inactive_file"]
+	}
+	sample.MemoryUsage = stats.MemoryStats.Usage
+	if cache < sample.MemoryUsage {
+		sample.MemoryUsage -= cache
+	}
+	if sample.MemoryLimit > 0 {
+		sample.MemoryPercent = float64(sample.MemoryUsage) / float64(sample.MemoryLimit) * 100
+	}
+
+	for _, network := range stats.Networks {
+		sample.NetworkRx += network.RxBytes
+		sample.NetworkTx += network.TxBytes
+	}
+
+	for _, entry := range stats.BlkioStats.IoServiceBytesRecursive {
+		switch strings.ToLower(entry.Op) {
+		case "read":
+			sample.BlockRead += entry.Value
+		case "write":
+			sample.BlockWrite += entry.Value
+		}
+	}
+
+	return sample
+}
@@ -0,0 +1,107 @@
+package dockerharness
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/docker/docker/api/types/container"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestContainerStatsCalculation(t *testing.T) {
+	stats := container.StatsResponse{
+		Read: time.Unix(100, 0),
+		CPUStats: container.CPUStats{
+			CPUUsage:    container.CPUUsage{TotalUsage: 3_000},
+			SystemUsage: 20_000,
+			OnlineCPUs:  4,
+		},
+		PreCPUStats: container.CPUStats{
+			CPUUsage:    container.CPUUsage{TotalUsage: 1_000},
+			SystemUsage: 10_000,
+		},
+		MemoryStats: container.MemoryStats{
+			Usage: 300,
+			Limit: 1_000,
+			Stats: map[string]uint64{"inactive_file": 100},
+		},
+		Networks: map[string]container.NetworkStats{
+			"eth0": {RxBytes: 10, TxBytes: 20},
+			"eth1": {RxBytes: 1, TxBytes: 2},
+		},
+		BlkioStats: container.BlkioStats{
+			IoServiceBytesRecursive: []container.BlkioStatEntry{
+				{Op: "Read", Value: 5},
+				{Op: "write", Value: 7},
+				{Op: "Total", Value: 12},
+			},
+		},
+		PidsStats: container.PidsStats{Current: 3},
+	}
+
+	sample := containerStats(stats)
+	assert.Equal(t, time.Unix(100, 0), sample.Time)
+	// 2000 of 10000 system ticks across 4 CPUs
+	assert.InDelta(t, 80.0, sample.CPUPercent, 0.001)
+	assert.Equal(t, uint64(200), sample.MemoryUsage)
+	assert.Equal(t, uint64(1_000), sample.MemoryLimit)
+	assert.InDelta(t, 20.0, sample.MemoryPercent, 0.001)
+	assert.Equal(t, uint64(11), sample.NetworkRx)
+	assert.Equal(t, uint64(22), sample.NetworkTx)
+	assert.Equal(t, uint64(5), sample.BlockRead)
+	assert.Equal(t, uint64(7), sample.BlockWrite)
+	assert.Equal(t, uint64(3), sample.PIDs)
+
+	// The first sample has no previous CPU usage to compare against
+	sample = containerStats(container.StatsResponse{})
+	assert.Equal(t, 0.0, sample.CPUPercent)
+	assert.Equal(t, 0.0, sample.MemoryPercent)
+}
+
+func TestContainerInspectAndStats(t *testing.T) {
+	c, err := NewContainerWithOptions(ContainerOptions{
+		Name:      t.Name(),
+		Image:     "alpine",
+		Tag:       "3",
+		Cmd:       []string{"sleep", "60"},
+		Mounts:    []Mount{{Type: "tmpfs", Target: "/scratch"}},
+		Resources: Resources{Memory: 64 << 20},
+	})
+	require.Nil(t, err)
+
+	err = c.Start()
+	require.Nil(t, err)
+	defer c.Cleanup()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+
+	info, err := c.Inspect(ctx)
+	require.Nil(t, err)
+	assert.Equal(t, c.GetContainerID(), info.ID)
+	assert.Equal(t, t.Name(), info.Name)
+	assert.Equal(t, "alpine:3", info.Image)
+	assert.Equal(t, "running", info.State.Status)
+	assert.True(t, info.State.Running)
+	assert.False(t, info.State.StartedAt.IsZero())
+	assert.Contains(t, info.Networks, "bridge")
+	assert.NotEmpty(t, info.Networks["bridge"].IPAddress)
+	require.Len(t, info.Mounts, 1)
+	assert.Equal(t, "/scratch", info.Mounts[0].Target)
+
+	// The workload stays within its memory budget
+	samples, err := c.Stats(ctx)
+	require.Nil(t, err)
+	for range 2 {
+		sample, ok := <-samples
+		require.True(t, ok)
+		assert.Equal(t, uint64(64<<20), sample.MemoryLimit)
+		assert.Less(t, sample.MemoryUsage, uint64(16<<20))
+	}
+
+	sample, err := c.StatsOnce(ctx)
+	require.Nil(t, err)
+	assert.Equal(t, uint64(64<<20), sample.MemoryLimit)
+}
@@ -0,0 +1,38 @@
+package dockerharness
+
+import (
+	"fmt"
+	"os"
+	"time"
+)
+
+const (
+	// LabelManaged is set on every container and network the harness
+	// creates.
+	LabelManaged = "docker-harness.managed"
+	// LabelSession identifies the process that created a resource; see
+	// SessionID.
+	LabelSession = "docker-harness.session"
+	// LabelShared is set on shared containers to their configuration key.
+	LabelShared = "docker-harness.shared"
+
+	// ComposeProjectPrefix starts every generated compose project name.
+	ComposeProjectPrefix = "docker-harness-"
+)
+
+var sessionID = fmt.Sprintf("%d-%d", os.Getpid(), time.Now().UnixNano())
+
+/*
+SessionID returns the identifier of the current process's harness
+session. Every resource created by this process is labelled with it.
+*/
+func SessionID() string {
+	return sessionID
+}
+
+func managedLabels() map[string]string {
+	return map[string]string{
+		LabelManaged: "true",
+		LabelSession: sessionID,
+	}
+}
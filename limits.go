@@ -0,0 +1,195 @@
+package dockerharness
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/docker/docker/api/types/container"
+)
+
+/*
+Resources are the limits and runtime constraints to run a container
+with, such as to reproduce a production memory limit in a test. Zero
+values leave docker's defaults in place.
+*/
+type Resources struct {
+	// Memory is the memory limit in bytes
+	Memory int64
+	// MemorySwap is the memory plus swap limit in bytes; -1 allows
+	// unlimited swap
+	MemorySwap int64
+
+	// NanoCPUs limits the container to a number of CPUs in billionths,
+	// such as 1_500_000_000 for one and a half CPUs
+	NanoCPUs int64
+	// CPUQuota is the CPU time in microseconds the container may use
+	// every CPUPeriod (100000 microseconds by default)
+	CPUQuota  int64
+	CPUPeriod int64
+	// CPUShares is the container's relative weight when CPUs are
+	// contended; docker's default is 1024
+	CPUShares int64
+	// CpusetCpus pins the container to specific CPUs, such as "0-2" or
+	// "0,3"
+	CpusetCpus string
+
+	// PidsLimit is the maximum number of processes in the container
+	PidsLimit int64
+	Ulimits   []Ulimit
+	Sysctls   map[string]string
+	// ShmSize is the size of /dev/shm in bytes
+	ShmSize int64
+
+	// RestartPolicy is "no", "always", "on-failure" or
+	// "unless-stopped"; RestartRetries limits "on-failure" restarts
+	RestartPolicy  string
+	RestartRetries int
+}
+
+type Ulimit struct {
+	// Name is the limit, such as "nofile" or "nproc"
+	Name string
+	Soft int64
+	Hard int64
+}
+
+/*
+ContainerState is the runtime state of a container as reported by
+docker, including whether it was killed for running out of memory.
+*/
+type ContainerState struct {
+	// Status is "created", "running", "paused", "restarting",
+	// "removing", "exited" or "dead"
+	Status    string
+	Running   bool
+	OOMKilled bool
+	ExitCode  int
+	Error     string
+	// Health is "starting", "healthy" or "unhealthy", or blank if the
+	// container has no healthcheck
+	Health     string
+	Restarts   int
+	StartedAt  time.Time
+	FinishedAt time.Time
+}
+
+/*
+State will return the container's current state. Checking OOMKilled
+and ExitCode after the container exits shows whether it was killed
+for exceeding its memory limit.
+*/
+func (c *Container) State() (ContainerState, error) {
+	if c.id == "" {
+		return ContainerState{}, errors.New("container has not been started")
+	}
+
+	inspect, err := c.client.ContainerInspect(context.Background(), c.id)
+	if err != nil {
+		return ContainerState{}, err
+	}
+
+	return containerState(inspect)
+}
+
+// containerState converts docker's inspect output into a ContainerState.
+func containerState(inspect container.InspectResponse) (ContainerState, error) {
+	if inspect.ContainerJSONBase == nil {
+		return ContainerState{}, errors.New("container has no state")
+	}
+	if inspect.State == nil {
+		return ContainerState{}, fmt.Errorf("container %s has no state", inspect.ID)
+	}
+
+	state := ContainerState{
+		Status:     string(inspect.State.Status),
+		Running:    inspect.State.Running,
+		OOMKilled:  inspect.State.OOMKilled,
+		ExitCode:   inspect.State.ExitCode,
+		Error:      inspect.State.Error,
+		Restarts:   inspect.RestartCount,
+		StartedAt:  parseDockerTime(inspect.State.StartedAt),
+		FinishedAt: parseDockerTime(inspect.State.FinishedAt),
+	}
+	if inspect.State.Health != nil {
+		state.Health = string(inspect.State.Health.Status)
+	}
+	return state, nil
+}
+
+// validate checks the resources for mistakes docker would only report
+// once the container is created.
+func (r Resources) validate() error {
+	switch container.RestartPolicyMode(r.RestartPolicy) {
+	case "", container.RestartPolicyDisabled, container.RestartPolicyAlways, container.RestartPolicyUnlessStopped:
+		if r.RestartRetries != 0 {
+			return errors.New("restart retries require the on-failure restart policy")
+		}
+	case container.RestartPolicyOnFailure:
+	default:
+		return fmt.Errorf("unknown restart policy %s", r.RestartPolicy)
+	}
+
+	if r.MemorySwap > 0 && r.MemorySwap < r.Memory {
+		return errors.New("memory swap limit must be at least the memory limit")
+	}
+	if r.MemorySwap != 0 && r.Memory == 0 {
+		return errors.New("memory swap limit requires a memory limit")
+	}
+	if r.NanoCPUs != 0 && (r.CPUQuota != 0 || r.CPUPeriod != 0) {
+		return errors.New("NanoCPUs cannot be combined with CPUQuota or CPUPeriod")
+	}
+
+	for _, ulimit := range r.Ulimits {
+		if ulimit.Name == "" {
+			return errors.New("ulimit name is required")
+		}
+		if ulimit.Soft > ulimit.Hard {
+			return fmt.Errorf("ulimit %s soft limit is above its hard limit", ulimit.Name)
+		}
+	}
+
+	return nil
+}
+
+// apply sets the resources on a container's host config.
+func (r Resources) apply(hostConfig *container.HostConfig) {
+	hostConfig.Memory = r.Memory
+	hostConfig.MemorySwap = r.MemorySwap
+	hostConfig.NanoCPUs = r.NanoCPUs
+	hostConfig.CPUQuota = r.CPUQuota
+	hostConfig.CPUPeriod = r.CPUPeriod
+	hostConfig.CPUShares = r.CPUShares
+	hostConfig.CpusetCpus = r.CpusetCpus
+	hostConfig.ShmSize = r.ShmSize
+	hostConfig.Sysctls = r.Sysctls
+
+	if r.PidsLimit != 0 {
+		limit := r.PidsLimit
+		hostConfig.PidsLimit = &limit
+	}
+	for _, ulimit := range r.Ulimits {
+		hostConfig.Ulimits = append(hostConfig.Ulimits, &container.Ulimit{
+			Name: ulimit.Name,
+			Soft: ulimit.Soft,
+			Hard: ulimit.Hard,
+		})
+	}
+	if r.RestartPolicy != "" {
+		hostConfig.RestartPolicy = container.RestartPolicy{
+			Name:              container.RestartPolicyMode(r.RestartPolicy),
+			MaximumRetryCount: r.RestartRetries,
+		}
+	}
+}
+
+// parseDockerTime parses the timestamps docker reports, returning the
+// zero time for timestamps that are unset.
+func parseDockerTime(value string) time.Time {
+	parsed, err := time.Parse(time.RFC3339Nano, value)
+	if err != nil || parsed.Year() <= 1 {
+		return time.Time{}
+	}
+	return parsed
+}
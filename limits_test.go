@@ -0,0 +1,140 @@
+package dockerharness
+
+import (
+	"testing"
+	"time"
+
+	"github.com/docker/docker/api/types/container"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestResourcesValidate(t *testing.T) {
+	require.Nil(t, Resources{}.validate())
+	require.Nil(t, Resources{
+		Memory:         64 << 20,
+		MemorySwap:     -1,
+		RestartPolicy:  "on-failure",
+		RestartRetries: 3,
+		Ulimits:        []Ulimit{{Name: "nofile", Soft: 1024, Hard: 2048}},
+	}.validate())
+
+	invalid := map[string]Resources{
+		"unknown restart policy":     {RestartPolicy: "sometimes"},
+		"retries without on-failure": {RestartPolicy: "always", RestartRetries: 2},
+		"swap below memory":          {Memory: 64 << 20, MemorySwap: 32 << 20},
+		"swap without memory":        {MemorySwap: 64 << 20},
+		"nano cpus with quota":       {NanoCPUs: 1e9, CPUQuota: 50000},
+		"unnamed ulimit":             {Ulimits: []Ulimit{{Soft: 1, Hard: 1}}},
+		"soft above hard":            {Ulimits: []Ulimit{{Name: "nproc", Soft: 2, Hard: 1}}},
+	}
+	for name, resources := range invalid {
+		t.Run(name, func(t *testing.T) {
+			assert.NotNil(t, resources.validate())
+		})
+	}
+
+	// Invalid resources are caught when the container is created
+	_, err := NewContainerWithOptions(ContainerOptions{
+		Image:     "alpine",
+		Resources: Resources{RestartPolicy: "sometimes"},
+	})
+	require.NotNil(t, err)
+}
+
+func TestResourcesApply(t *testing.T) {
+	hostConfig := &container.HostConfig{}
+	Resources{
+		Memory:         64 << 20,
+		MemorySwap:     128 << 20,
+		CPUShares:      512,
+		CpusetCpus:     "0",
+		PidsLimit:      100,
+		Ulimits:        []Ulimit{{Name: "nofile", Soft: 1024, Hard: 2048}},
+		Sysctls:        map[string]string{"net.core.somaxconn": "1024"},
+		ShmSize:        256 << 20,
+		RestartPolicy:  "on-failure",
+		RestartRetries: 3,
+	}.apply(hostConfig)
+
+	assert.Equal(t, int64(64<<20), hostConfig.Memory)
+	assert.Equal(t, int64(128<<20), hostConfig.MemorySwap)
+	assert.Equal(t, int64(512), hostConfig.CPUShares)
+	assert.Equal(t, "0", hostConfig.CpusetCpus)
+	require.NotNil(t, hostConfig.PidsLimit)
+	assert.Equal(t, int64(100), *hostConfig.PidsLimit)
+	require.Len(t, hostConfig.Ulimits, 1)
+	assert.Equal(t, &container.Ulimit{Name: "nofile", Soft: 1024, Hard: 2048}, hostConfig.Ulimits[0])
+	assert.Equal(t, "1024", hostConfig.Sysctls["net.core.somaxconn"])
+	assert.Equal(t, int64(256<<20), hostConfig.ShmSize)
+	assert.Equal(t, container.RestartPolicy{Name: "on-failure", MaximumRetryCount: 3}, hostConfig.RestartPolicy)
+
+	// Unset limits leave docker's defaults alone
+	hostConfig = &container.HostConfig{}
+	Resources{}.apply(hostConfig)
+	assert.Nil(t, hostConfig.PidsLimit)
+	assert.Equal(t, container.RestartPolicy{}, hostConfig.RestartPolicy)
+}
+
+func TestContainerStateMissing(t *testing.T) {
+	_, err := containerState(container.InspectResponse{})
+	require.NotNil(t, err)
+
+	_, err = containerState(container.InspectResponse{
+		ContainerJSONBase: &container.ContainerJSONBase{ID: "abc"},
+	})
+	require.NotNil(t, err)
+	assert.Contains(t, err.Error(), "abc")
+
+	state, err := containerState(container.InspectResponse{
+		ContainerJSONBase: &container.ContainerJSONBase{
+			ID:           "abc",
+			RestartCount: 2,
+			State: &container.State{
+				Status:   container.StateExited,
+				ExitCode: 1,
+				Health:   &container.Health{Status: container.Unhealthy},
+			},
+		},
+	})
+	require.Nil(t, err)
+	assert.Equal(t, "exited", state.Status)
+	assert.Equal(t, 1, state.ExitCode)
+	assert.Equal(t, 2, state.Restarts)
+	assert.Equal(t, "unhealthy", state.Health)
+}
+
+func TestContainerOOMKilled(t *testing.T) {
+	c, err := NewContainerWithOptions(ContainerOptions{
+		Name:  t.Name(),
+		Image: "alpine",
+		Tag:   "3",
+		// Allocate far more than the memory limit allows
+		Cmd: []string{"sh", "-c", "head -c 256m /dev/zero | tail"},
+		Resources: Resources{
+			Memory:     16 << 20,
+			MemorySwap: 16 << 20,
+		},
+	})
+	require.Nil(t, err)
+
+	err = c.Start()
+	require.Nil(t, err)
+	defer c.Cleanup()
+
+	// Wait for the kernel to kill it
+	var state ContainerState
+	for range 100 {
+		state, err = c.State()
+		require.Nil(t, err)
+		if !state.Running {
+			break
+		}
+		time.Sleep(100 * time.Millisecond)
+	}
+
+	assert.False(t, state.Running)
+	assert.True(t, state.OOMKilled)
+	assert.Equal(t, 137, state.ExitCode)
+	assert.False(t, state.FinishedAt.IsZero())
+}
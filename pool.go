@@ -0,0 +1,291 @@
+package dockerharness
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+)
+
+var ErrPoolClosed = errors.New("pool is closed")
+
+/*
+PoolItem is anything a Pool can hand out - any harness, or any of the
+database wrappers.
+*/
+type PoolItem interface {
+	comparable
+	Cleanup() error
+}
+
+type PoolOptions[T PoolItem] struct {
+	// Size is the number of ready instances the pool tries to keep
+	// started and waiting at all times.
+	Size int
+	// MaxSize is the most instances, ready or acquired, the pool will
+	// ever have at once. Defaults to Size.
+	MaxSize int
+	// New creates and starts an instance, returning once it is ready
+	// to be used.
+	New func(ctx context.Context) (T, error)
+	// Reset returns a released instance to a clean state so it can be
+	// handed out again. If Reset is nil or fails, released instances
+	// are discarded and replaced instead.
+	Reset func(ctx context.Context, item T) error
+}
+
+/*
+Pool keeps a number of started harnesses warm so that parallel tests can
+each own an isolated instance without paying its startup time.
+*/
+type Pool[T PoolItem] struct {
+	size    int
+	maxSize int
+	new     func(ctx context.Context) (T, error)
+	reset   func(ctx context.Context, item T) error
+
+	ready    chan T
+	items    map[T]struct{}
+	creating int
+	// failure is closed, and replaced, when the next start fails, so
+	// that every Acquire waiting at the time sees the error
+	failure *poolFailure
+	closed  bool
+	done    chan struct{}
+	ctx     context.Context
+	cancel  context.CancelFunc
+	wg      sync.WaitGroup
+
+	lock sync.Mutex
+}
+
+// poolFailure is a start that failed; err is set before done is closed.
+type poolFailure struct {
+	done chan struct{}
+	err  error
+}
+
+func newPoolFailure() *poolFailure {
+	return &poolFailure{done: make(chan struct{})}
+}
+
+/*
+NewPool will create a pool and begin starting its instances in the
+background.
+*/
+func NewPool[T PoolItem](options PoolOptions[T]) (*Pool[T], error) {
+	if options.New == nil {
+		return nil, errors.New("pool requires a New function")
+	}
+	if options.Size < 0 {
+		return nil, errors.New("pool size cannot be negative")
+	}
+
+	maxSize := options.MaxSize
+	if maxSize == 0 {
+		maxSize = options.Size
+	}
+	if maxSize <= 0 {
+		return nil, errors.New("pool max size must be greater than zero")
+	}
+	if maxSize < options.Size {
+		return nil, errors.New("pool max size cannot be less than its size")
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	p := &Pool[T]{
+		size:    options.Size,
+		maxSize: maxSize,
+		new:     options.New,
+		reset:   options.Reset,
+		ready:   make(chan T, maxSize),
+		items:   map[T]struct{}{},
+		failure: newPoolFailure(),
+		done:    make(chan struct{}),
+		ctx:     ctx,
+		cancel:  cancel,
+	}
+	p.fill(false)
+
+	return p, nil
+}
+
+/*
+Acquire will return a ready instance, waiting for one to be started or
+released if none are available. The caller owns the instance until it
+is passed back to Release.
+*/
+func (p *Pool[T]) Acquire(ctx context.Context) (T, error) {
+	var zero T
+
+	select {
+	case <-p.done:
+		return zero, ErrPoolClosed
+	default:
+	}
+
+	// Prefer an instance that is already waiting
+	select {
+	case item := <-p.ready:
+		p.fill(false)
+		return item, nil
+	default:
+	}
+
+	// Only starts that fail from here on are reported to this call
+	p.lock.Lock()
+	failure := p.failure
+	p.lock.Unlock()
+
+	p.fill(true)
+	select {
+	case item := <-p.ready:
+		p.fill(false)
+		return item, nil
+	case <-failure.done:
+		return zero, fmt.Errorf("failed to start pool instance: %w", failure.err)
+	case <-p.done:
+		return zero, ErrPoolClosed
+	case <-ctx.Done():
+		return zero, ctx.Err()
+	}
+}
+
+/*
+Release will hand an acquired instance back to the pool. The instance
+is reset and made available again, or cleaned up and replaced if there
+is no reset function or the reset fails.
+*/
+func (p *Pool[T]) Release(item T) error {
+	var resetErr error
+	if p.reset != nil {
+		resetErr = p.reset(p.ctx, item)
+		if resetErr == nil {
+			p.lock.Lock()
+			if _, ok := p.items[item]; ok && !p.closed {
+				p.ready <- item
+				p.lock.Unlock()
+				return nil
+			}
+			p.lock.Unlock()
+		} else {
+			resetErr = fmt.Errorf("failed to reset pool instance: %w", resetErr)
+		}
+	}
+
+	return errors.Join(resetErr, p.discard(item))
+}
+
+/*
+Close will stop refilling the pool and clean up every instance it
+created, including any that are still acquired.
+*/
+func (p *Pool[T]) Close() error {
+	p.lock.Lock()
+	if p.closed {
+		p.lock.Unlock()
+		return nil
+	}
+	p.closed = true
+	close(p.done)
+	p.cancel()
+	p.lock.Unlock()
+
+	// Wait for in-flight starts; they clean up after themselves once
+	// they see the pool is closed
+	p.wg.Wait()
+
+	p.lock.Lock()
+	items := make([]T, 0, len(p.items))
+	for item := range p.items {
+		items = append(items, item)
+	}
+	p.items = map[T]struct{}{}
+	p.lock.Unlock()
+
+	errs := make([]error, len(items))
+	wg := sync.WaitGroup{}
+	for i, item := range items {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			errs[i] = item.Cleanup()
+		}()
+	}
+	wg.Wait()
+
+	return errors.Join(errs...)
+}
+
+// discard removes an instance from the pool, cleans it up and starts a
+// replacement if one is needed.
+func (p *Pool[T]) discard(item T) error {
+	p.lock.Lock()
+	_, ok := p.items[item]
+	delete(p.items, item)
+	p.lock.Unlock()
+
+	if !ok {
+		return nil
+	}
+
+	err := item.Cleanup()
+	p.fill(false)
+
+	return err
+}
+
+// fill starts as many new instances in the background as are needed to
+// bring the pool back to its size without exceeding its max size.
+func (p *Pool[T]) fill(waiting bool) {
+	p.lock.Lock()
+	defer p.lock.Unlock()
+
+	if p.closed {
+		return
+	}
+
+	need := p.size - len(p.ready) - p.creating
+	if available := p.maxSize - len(p.items) - p.creating; need > available {
+		need = available
+	}
+
+	// A caller is waiting on an empty pool, so start one for them even
+	// if the pool is otherwise at its size
+	if waiting && need <= 0 && len(p.ready) == 0 && p.creating == 0 && len(p.items) < p.maxSize {
+		need = 1
+	}
+
+	for range need {
+		p.creating++
+		p.wg.Add(1)
+		go p.create()
+	}
+}
+
+func (p *Pool[T]) create() {
+	defer p.wg.Done()
+
+	item, err := p.new(p.ctx)
+
+	p.lock.Lock()
+	defer p.lock.Unlock()
+
+	p.creating--
+	if err != nil {
+		// A failed background fill is retried by the next Acquire, so
+		// the error only matters to callers already waiting
+		p.failure.err = err
+		close(p.failure.done)
+		p.failure = newPoolFailure()
+		return
+	}
+
+	if p.closed {
+		item.Cleanup()
+		return
+	}
+
+	p.items[item] = struct{}{}
+	p.ready <- item
+}
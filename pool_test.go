@@ -0,0 +1,245 @@
+package dockerharness
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+type poolTestItem struct {
+	id      int64
+	dirty   bool
+	cleaned atomic.Bool
+}
+
+func (i *poolTestItem) Cleanup() error {
+	i.cleaned.Store(true)
+	return nil
+}
+
+func newPoolTestFactory() (func(ctx context.Context) (*poolTestItem, error), *atomic.Int64) {
+	created := &atomic.Int64{}
+	return func(ctx context.Context) (*poolTestItem, error) {
+		return &poolTestItem{id: created.Add(1)}, nil
+	}, created
+}
+
+func TestPoolAcquireRelease(t *testing.T) {
+	factory, created := newPoolTestFactory()
+	pool, err := NewPool(PoolOptions[*poolTestItem]{
+		Size: 2,
+		New:  factory,
+		Reset: func(ctx context.Context, item *poolTestItem) error {
+			item.dirty = false
+			return nil
+		},
+	})
+	require.Nil(t, err)
+	defer pool.Close()
+
+	// The pool should warm up to its size on its own
+	assert.Eventually(t, func() bool { return created.Load() == 2 }, time.Second, 10*time.Millisecond)
+
+	item, err := pool.Acquire(context.Background())
+	require.Nil(t, err)
+	item.dirty = true
+
+	// Releasing resets the instance and makes it available
+	// again rather than creating a new one
+	err = pool.Release(item)
+	require.Nil(t, err)
+	assert.False(t, item.dirty)
+	assert.False(t, item.cleaned.Load())
+
+	assert.Equal(t, int64(2), created.Load())
+}
+
+func TestPoolDiscardsWithoutReset(t *testing.T) {
+	factory, created := newPoolTestFactory()
+	pool, err := NewPool(PoolOptions[*poolTestItem]{
+		Size: 1,
+		New:  factory,
+	})
+	require.Nil(t, err)
+	defer pool.Close()
+
+	item, err := pool.Acquire(context.Background())
+	require.Nil(t, err)
+
+	// Without a reset function the instance is cleaned up and
+	// replaced with a fresh one
+	err = pool.Release(item)
+	require.Nil(t, err)
+	assert.True(t, item.cleaned.Load())
+
+	next, err := pool.Acquire(context.Background())
+	require.Nil(t, err)
+	assert.NotEqual(t, item.id, next.id)
+	assert.GreaterOrEqual(t, created.Load(), int64(2))
+}
+
+func TestPoolDiscardsOnFailedReset(t *testing.T) {
+	factory, _ := newPoolTestFactory()
+	pool, err := NewPool(PoolOptions[*poolTestItem]{
+		Size: 1,
+		New:  factory,
+		Reset: func(ctx context.Context, item *poolTestItem) error {
+			return errors.New("reset failed")
+		},
+	})
+	require.Nil(t, err)
+	defer pool.Close()
+
+	item, err := pool.Acquire(context.Background())
+	require.Nil(t, err)
+
+	err = pool.Release(item)
+	assert.NotNil(t, err)
+	assert.True(t, item.cleaned.Load())
+}
+
+func TestPoolMaxSize(t *testing.T) {
+	factory, created := newPoolTestFactory()
+	pool, err := NewPool(PoolOptions[*poolTestItem]{
+		Size:    1,
+		MaxSize: 2,
+		New:     factory,
+	})
+	require.Nil(t, err)
+	defer pool.Close()
+
+	first, err := pool.Acquire(context.Background())
+	require.Nil(t, err)
+	second, err := pool.Acquire(context.Background())
+	require.Nil(t, err)
+
+	// The pool is at its max size, so a third acquire must wait
+	// until an instance is released
+	ctx, cancel := context.WithTimeout(context.Background(), 100*time.Millisecond)
+	defer cancel()
+	_, err = pool.Acquire(ctx)
+	assert.ErrorIs(t, err, context.DeadlineExceeded)
+	assert.Equal(t, int64(2), created.Load())
+
+	wg := sync.WaitGroup{}
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		third, err := pool.Acquire(context.Background())
+		assert.Nil(t, err)
+		assert.NotNil(t, third)
+	}()
+
+	require.Nil(t, pool.Release(first))
+	wg.Wait()
+
+	require.Nil(t, pool.Release(second))
+}
+
+func TestPoolClose(t *testing.T) {
+	factory, created := newPoolTestFactory()
+	pool, err := NewPool(PoolOptions[*poolTestItem]{
+		Size: 2,
+		New:  factory,
+	})
+	require.Nil(t, err)
+
+	item, err := pool.Acquire(context.Background())
+	require.Nil(t, err)
+	assert.Eventually(t, func() bool { return created.Load() == 2 }, time.Second, 10*time.Millisecond)
+
+	// Closing cleans up acquired instances as well as ready ones
+	err = pool.Close()
+	require.Nil(t, err)
+	assert.True(t, item.cleaned.Load())
+
+	_, err = pool.Acquire(context.Background())
+	assert.ErrorIs(t, err, ErrPoolClosed)
+}
+
+func TestPoolNewFailure(t *testing.T) {
+	pool, err := NewPool(PoolOptions[*poolTestItem]{
+		Size: 1,
+		New: func(ctx context.Context) (*poolTestItem, error) {
+			return nil, errors.New("could not start")
+		},
+	})
+	require.Nil(t, err)
+	defer pool.Close()
+
+	_, err = pool.Acquire(context.Background())
+	assert.ErrorContains(t, err, "could not start")
+}
+
+func TestPoolRecoversFromNewFailure(t *testing.T) {
+	calls := &atomic.Int64{}
+	pool, err := NewPool(PoolOptions[*poolTestItem]{
+		Size: 1,
+		New: func(ctx context.Context) (*poolTestItem, error) {
+			if calls.Add(1) == 1 {
+				return nil, errors.New("could not start")
+			}
+			return &poolTestItem{id: calls.Load()}, nil
+		},
+	})
+	require.Nil(t, err)
+	defer pool.Close()
+
+	// Let the failing background fill finish with nobody waiting
+	require.Eventually(t, func() bool {
+		pool.lock.Lock()
+		defer pool.lock.Unlock()
+		return calls.Load() == 1 && pool.creating == 0
+	}, time.Second, 10*time.Millisecond)
+
+	item, err := pool.Acquire(context.Background())
+	require.Nil(t, err)
+	assert.Equal(t, int64(2), item.id)
+}
+
+func TestPoolNewFailureReachesEveryWaiter(t *testing.T) {
+	started := make(chan struct{})
+	fail := make(chan struct{})
+	pool, err := NewPool(PoolOptions[*poolTestItem]{
+		Size:    0,
+		MaxSize: 2,
+		New: func(ctx context.Context) (*poolTestItem, error) {
+			close(started)
+			<-fail
+			return nil, errors.New("boom")
+		},
+	})
+	require.Nil(t, err)
+	defer pool.Close()
+
+	// Both callers wait on the one start the first of them began
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	errs := make([]error, 2)
+	wg := sync.WaitGroup{}
+	for i := range errs {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			_, errs[i] = pool.Acquire(ctx)
+		}()
+		if i == 0 {
+			<-started
+		}
+	}
+	// Give the second caller time to start waiting
+	time.Sleep(50 * time.Millisecond)
+	close(fail)
+	wg.Wait()
+
+	for _, err := range errs {
+		require.NotNil(t, err)
+		assert.ErrorContains(t, err, "boom")
+	}
+}
@@ -0,0 +1,139 @@
+package dockerharness
+
+import (
+	"fmt"
+	"os"
+	"os/signal"
+	"sync"
+	"syscall"
+	"time"
+)
+
+const defaultSignalCleanupTimeout = 30 * time.Second
+
+// registry tracks every harness that has been started and not yet
+// cleaned up, so that they can all be cleaned up at once on exit.
+var registry = struct {
+	harnesses map[Harness]struct{}
+	lock      sync.Mutex
+}{
+	harnesses: map[Harness]struct{}{},
+}
+
+/*
+Register will add a harness to the global registry so that it is
+cleaned up by CleanupRegistered and by the signal handler. Containers,
+compose projects, stacks and the database modules register themselves
+when started, so this is only needed for custom harnesses.
+*/
+func Register(harness Harness) {
+	registry.lock.Lock()
+	defer registry.lock.Unlock()
+
+	registry.harnesses[harness] = struct{}{}
+}
+
+/*
+Unregister will remove a harness from the global registry. Harnesses
+unregister themselves once successfully cleaned up. Harnesses that own
+others, such as a stack and its containers or a database module and its
+container, unregister their children and register themselves once
+started, so that each is cleaned up only once.
+*/
+func Unregister(harness Harness) {
+	registry.lock.Lock()
+	defer registry.lock.Unlock()
+
+	delete(registry.harnesses, harness)
+}
+
+/*
+CleanupRegistered will clean up every harness in the global registry -
+everything started in this process and not yet cleaned up - concurrently,
+like CleanupAll, which makes it suitable for TestMain.
+*/
+func CleanupRegistered() error {
+	return cleanupHarnesses(registered())
+}
+
+func registered() []Harness {
+	registry.lock.Lock()
+	defer registry.lock.Unlock()
+
+	harnesses := make([]Harness, 0, len(registry.harnesses))
+	for harness := range registry.harnesses {
+		harnesses = append(harnesses, harness)
+	}
+	return harnesses
+}
+
+/*
+InstallSignalHandler will clean up every registered harness when the
+process receives SIGINT or SIGTERM, such as a Ctrl-C during `go test`.
+Cleanups run in parallel and are abandoned after the timeout (30
+seconds if the timeout is zero), after which the signal is re-raised
+so that the process exits as it otherwise would have. The returned
+function uninstalls the handler.
+*/
+func InstallSignalHandler(timeout time.Duration) func() {
+	if timeout <= 0 {
+		timeout = defaultSignalCleanupTimeout
+	}
+
+	signals := make(chan os.Signal, 1)
+	stop := make(chan struct{})
+	signal.Notify(signals, os.Interrupt, syscall.SIGTERM)
+
+	go func() {
+		select {
+		case sig := <-signals:
+			signal.Stop(signals)
+			if err := cleanupRegisteredWithin(timeout); err != nil {
+				fmt.Fprintf(os.Stderr, "docker-harness: cleanup on %s: %v\n", sig, err)
+			}
+			reraise(sig)
+		case <-stop:
+		}
+	}()
+
+	once := sync.Once{}
+	return func() {
+		once.Do(func() {
+			signal.Stop(signals)
+			close(stop)
+		})
+	}
+}
+
+// cleanupRegisteredWithin cleans up every registered harness, giving up
+// after the timeout.
+func cleanupRegisteredWithin(timeout time.Duration) error {
+	done := make(chan error, 1)
+	go func() {
+		done <- CleanupRegistered()
+	}()
+
+	select {
+	case err := <-done:
+		return err
+	case <-time.After(timeout):
+		return fmt.Errorf("timed out after %s", timeout)
+	}
+}
+
+// reraise delivers the signal to ourselves again with its default
+// behaviour restored, falling back to exiting if that is not possible.
+func reraise(sig os.Signal) {
+	signal.Reset(sig)
+
+	process, err := os.FindProcess(os.Getpid())
+	if err == nil {
+		err = process.Signal(sig)
+	}
+	if err == nil {
+		// Give the signal a moment to be delivered
+		time.Sleep(time.Second)
+	}
+
+	os.Exit(1)
+}
@@ -0,0 +1,73 @@
+package dockerharness
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestCleanupRegistered(t *testing.T) {
+	first := &groupTestHarness{}
+	second := &groupTestHarness{}
+	unregistered := &groupTestHarness{}
+
+	Register(first)
+	Register(second)
+	Register(unregistered)
+	Unregister(unregistered)
+	defer Unregister(first)
+	defer Unregister(second)
+
+	// CleanupAll with no arguments leaves the registry alone
+	require.Nil(t, CleanupAll())
+	assert.False(t, first.cleaned.Load())
+	assert.False(t, second.cleaned.Load())
+
+	// CleanupRegistered cleans up only what is in the registry
+	err := CleanupRegistered()
+	require.Nil(t, err)
+	assert.True(t, first.cleaned.Load())
+	assert.True(t, second.cleaned.Load())
+	assert.False(t, unregistered.cleaned.Load())
+}
+
+func TestRegistryCleanupTimeout(t *testing.T) {
+	slow := &slowCleanupHarness{delay: time.Second}
+	Register(slow)
+	defer Unregister(slow)
+
+	start := time.Now()
+	err := cleanupRegisteredWithin(50 * time.Millisecond)
+	assert.ErrorContains(t, err, "timed out")
+	assert.Less(t, time.Since(start), 500*time.Millisecond)
+}
+
+func TestComposeRegistersOnStart(t *testing.T) {
+	requireCompose(t)
+
+	compose, err := NewCompose(composeTestName(t), []string{composeFile("env.yml")})
+	require.Nil(t, err)
+
+	compose.env = map[string]string{"HARNESS_VALUE": "expected"}
+	err = compose.Start()
+	require.Nil(t, err)
+	defer compose.Cleanup()
+
+	assert.Contains(t, registered(), Harness(compose))
+
+	err = compose.Cleanup()
+	require.Nil(t, err)
+	assert.NotContains(t, registered(), Harness(compose))
+}
+
+type slowCleanupHarness struct {
+	groupTestHarness
+	delay time.Duration
+}
+
+func (h *slowCleanupHarness) Cleanup() error {
+	time.Sleep(h.delay)
+	return h.groupTestHarness.Cleanup()
+}
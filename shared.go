@@ -0,0 +1,414 @@
+package dockerharness
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"slices"
+	"sort"
+	"strings"
+
+	docker "github.com/docker/docker/client"
+)
+
+// ErrSharedContainerInUse is returned when stopping a shared container
+// that other processes still hold.
+var ErrSharedContainerInUse = errors.New("shared container is in use by other processes")
+
+const (
+	sharedContainerPrefix = "docker-harness-shared-"
+
+	// SharedDirEnv can be set to override the directory that shared
+	// container state and lock files are kept in.
+	SharedDirEnv = "DOCKER_HARNESS_SHARED_DIR"
+)
+
+// sharedState is the on-disk record of a shared container. Holders
+// contains one process id per outstanding Start; a process that starts
+// the same shared container twice appears twice.
+type sharedState struct {
+	ContainerID string            `json:"container_id"`
+	Name        string            `json:"name"`
+	Ports       map[string]string `json:"ports"`
+	Volumes     []string          `json:"volumes"`
+	Holders     []int             `json:"holders"`
+}
+
+// sharedConfig is everything about a container that shared containers
+// are keyed by. WaitFor, WaitHealthy and WaitTimeout are left out as
+// they only change how each process waits, not the container itself.
+type sharedConfig struct {
+	Image       string            `json:"image"`
+	Cmd         []string          `json:"cmd,omitempty"`
+	Env         map[string]string `json:"env,omitempty"`
+	Ports       map[string]string `json:"ports,omitempty"`
+	Network     string            `json:"network,omitempty"`
+	Aliases     []string          `json:"aliases,omitempty"`
+	HostGateway bool              `json:"host_gateway,omitempty"`
+	ExtraHosts  []string          `json:"extra_hosts,omitempty"`
+	Mounts      []Mount           `json:"mounts,omitempty"`
+	Resources   Resources         `json:"resources"`
+	Healthcheck *Healthcheck      `json:"healthcheck,omitempty"`
+}
+
+/*
+SharedContainerKey returns the key shared containers are identified by.
+Two containers with the same configuration - image, tag, command,
+environment, ports, network and aliases, extra hosts, mounts, resources
+and healthcheck - will produce the same key, regardless of which process
+creates them; any difference produces another key. Ports without an
+assigned host port are keyed by their container port alone. The name
+and wait strategies are not part of the key.
+*/
+func SharedContainerKey(options ContainerOptions) string {
+	tag := options.Tag
+	if tag == "" {
+		tag = "latest"
+	}
+
+	mounts := []Mount{}
+	for _, m := range options.Mounts {
+		if m.Type == "" {
+			m.Type = "bind"
+		}
+		if m.Type == "bind" {
+			if source, err := filepath.Abs(m.Source); err == nil {
+				m.Source = source
+			}
+		}
+		mounts = append(mounts, m)
+	}
+
+	aliases := slices.Clone(options.Aliases)
+	sort.Strings(aliases)
+	extraHosts := slices.Clone(options.ExtraHosts)
+	sort.Strings(extraHosts)
+
+	// JSON writes map keys sorted, so the key does not depend on map
+	// ordering
+	config, err := json.Marshal(sharedConfig{
+		Image:       fmt.Sprintf("%s:%s", options.Image, tag),
+		Cmd:         options.Cmd,
+		Env:         options.Env,
+		Ports:       options.Ports,
+		Network:     options.Network,
+		Aliases:     aliases,
+		HostGateway: options.HostGateway,
+		ExtraHosts:  extraHosts,
+		Mounts:      mounts,
+		Resources:   options.Resources,
+		Healthcheck: options.Healthcheck,
+	})
+	if err != nil {
+		// Every field is a plain value, so this cannot happen
+		panic(fmt.Sprintf("failed to encode shared container config: %v", err))
+	}
+
+	sum := sha256.Sum256(config)
+	return hex.EncodeToString(sum[:])
+}
+
+/*
+ReapSharedContainers will remove every shared container whose holders
+have all exited without cleaning up, such as after a crashed or killed
+test process. Shared containers still in use are left alone.
+*/
+func ReapSharedContainers() error {
+	dir, err := sharedDir()
+	if err != nil {
+		return err
+	}
+
+	matches, err := filepath.Glob(filepath.Join(dir, "*.json"))
+	if err != nil {
+		return err
+	}
+	if len(matches) == 0 {
+		return nil
+	}
+
+	client, err := docker.NewClientWithOpts(docker.FromEnv)
+	if err != nil {
+		return err
+	}
+	defer client.Close()
+
+	var errs []error
+	for _, match := range matches {
+		key := strings.TrimSuffix(filepath.Base(match), ".json")
+		if err := reapSharedContainer(client, dir, key); err != nil {
+			errs = append(errs, fmt.Errorf("failed to reap shared container %s: %w", key, err))
+		}
+	}
+
+	return errors.Join(errs...)
+}
+
+/*
+SharedContainerHeld will return true if a live process still holds the
+shared container with the key, as found on its LabelShared label, such
+as to leave it alone when cleaning up from outside the tests.
+*/
+func SharedContainerHeld(key string) (bool, error) {
+	dir, err := sharedDir()
+	if err != nil {
+		return false, err
+	}
+
+	unlock, err := lockShared(dir, key)
+	if err != nil {
+		return false, err
+	}
+	defer unlock()
+
+	state, err := readSharedState(dir, key)
+	if err != nil {
+		return false, err
+	}
+	return len(liveHolders(state.Holders)) > 0, nil
+}
+
+func reapSharedContainer(client *docker.Client, dir string, key string) error {
+	unlock, err := lockShared(dir, key)
+	if err != nil {
+		return err
+	}
+	defer unlock()
+
+	state, err := readSharedState(dir, key)
+	if err != nil {
+		return err
+	}
+	state.Holders = liveHolders(state.Holders)
+	if len(state.Holders) > 0 {
+		return writeSharedState(dir, key, state)
+	}
+
+	if state.Name != "" {
+		if err := CleanupAndKillContainer(client, state.Name); err != nil {
+			return err
+		}
+	}
+
+	return removeSharedState(dir, key)
+}
+
+// startShared attaches to the shared container for this configuration if
+// another process has already started it, or starts it otherwise. The
+// caller must hold c.lock.
+func (c *Container) startShared() error {
+	dir, err := sharedDir()
+	if err != nil {
+		return err
+	}
+
+	unlock, err := lockShared(dir, c.sharedKey)
+	if err != nil {
+		return err
+	}
+	defer unlock()
+
+	state, err := readSharedState(dir, c.sharedKey)
+	if err != nil {
+		return err
+	}
+	state.Holders = liveHolders(state.Holders)
+
+	// Attach to the existing container if it is still running
+	if state.ContainerID != "" {
+		inspect, err := c.client.ContainerInspect(context.Background(), state.ContainerID)
+		if err != nil && !docker.IsErrNotFound(err) {
+			return err
+		} else if err == nil && inspect.State.Running {
+			c.id = state.ContainerID
+			c.ports = state.Ports
+			c.volumes = state.Volumes
+			state.Holders = append(state.Holders, os.Getpid())
+			return writeSharedState(dir, c.sharedKey, state)
+		}
+	}
+
+	// Otherwise we are the first; whatever is left over from a previous
+	// run is replaced by the normal same-name handling in start
+	if err := c.start(); err != nil {
+		return err
+	}
+
+	return writeSharedState(dir, c.sharedKey, &sharedState{
+		ContainerID: c.id,
+		Name:        c.name,
+		Ports:       c.ports,
+		Volumes:     c.volumes,
+		Holders:     []int{os.Getpid()},
+	})
+}
+
+// releaseShared drops this process's hold on the shared container,
+// removing the container if no other live process still holds it.
+func (c *Container) releaseShared() error {
+	c.lock.Lock()
+	if c.id == "" {
+		c.lock.Unlock()
+		return nil
+	}
+	c.lock.Unlock()
+
+	dir, err := sharedDir()
+	if err != nil {
+		return err
+	}
+
+	unlock, err := lockShared(dir, c.sharedKey)
+	if err != nil {
+		return err
+	}
+	defer unlock()
+
+	state, err := readSharedState(dir, c.sharedKey)
+	if err != nil {
+		return err
+	}
+
+	// Remove a single hold for this process, then drop any holders
+	// that exited without releasing theirs
+	pid := os.Getpid()
+	for i, holder := range state.Holders {
+		if holder == pid {
+			state.Holders = append(state.Holders[:i], state.Holders[i+1:]...)
+			break
+		}
+	}
+	state.Holders = liveHolders(state.Holders)
+
+	// If the shared container has been replaced since we attached, ours
+	// is an orphan; remove it but leave the current one alone
+	if state.ContainerID != c.id {
+		if err := c.cleanup(); err != nil {
+			return err
+		}
+		c.detach()
+		return writeSharedState(dir, c.sharedKey, state)
+	}
+
+	if len(state.Holders) > 0 {
+		c.detach()
+		return writeSharedState(dir, c.sharedKey, state)
+	}
+
+	if err := c.cleanup(); err != nil {
+		return err
+	}
+	c.detach()
+
+	return removeSharedState(dir, c.sharedKey)
+}
+
+// checkSharedStop returns ErrSharedContainerInUse if any process other
+// than this one still holds the shared container.
+func (c *Container) checkSharedStop() error {
+	dir, err := sharedDir()
+	if err != nil {
+		return err
+	}
+
+	unlock, err := lockShared(dir, c.sharedKey)
+	if err != nil {
+		return err
+	}
+	defer unlock()
+
+	state, err := readSharedState(dir, c.sharedKey)
+	if err != nil {
+		return err
+	}
+	if state.ContainerID != c.id {
+		// Ours has been replaced, so nobody else holds it
+		return nil
+	}
+	for _, holder := range liveHolders(state.Holders) {
+		if holder != os.Getpid() {
+			return ErrSharedContainerInUse
+		}
+	}
+
+	return nil
+}
+
+// detach forgets the container this harness was attached to without
+// touching the container itself.
+func (c *Container) detach() {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+
+	c.id = ""
+	c.volumes = nil
+}
+
+func sharedDir() (string, error) {
+	dir := os.Getenv(SharedDirEnv)
+	if dir == "" {
+		dir = filepath.Join(os.TempDir(), "docker-harness-shared")
+	}
+
+	if err := os.MkdirAll(dir, 0o755); err != nil {
+		return "", fmt.Errorf("failed to create shared state directory: %w", err)
+	}
+
+	return dir, nil
+}
+
+func readSharedState(dir string, key string) (*sharedState, error) {
+	state := &sharedState{}
+
+	data, err := os.ReadFile(filepath.Join(dir, key+".json"))
+	if errors.Is(err, os.ErrNotExist) {
+		return state, nil
+	} else if err != nil {
+		return nil, err
+	}
+
+	if err := json.Unmarshal(data, state); err != nil {
+		return nil, fmt.Errorf("failed to read shared state for %s: %w", key, err)
+	}
+
+	return state, nil
+}
+
+func writeSharedState(dir string, key string, state *sharedState) error {
+	data, err := json.Marshal(state)
+	if err != nil {
+		return err
+	}
+
+	// Write to a temporary file and rename it so a crash can never
+	// leave a half-written state file behind
+	tmp := filepath.Join(dir, key+".json.tmp")
+	if err := os.WriteFile(tmp, data, 0o644); err != nil {
+		return err
+	}
+
+	return os.Rename(tmp, filepath.Join(dir, key+".json"))
+}
+
+func removeSharedState(dir string, key string) error {
+	err := os.Remove(filepath.Join(dir, key+".json"))
+	if err != nil && !errors.Is(err, os.ErrNotExist) {
+		return err
+	}
+	return nil
+}
+
+func liveHolders(holders []int) []int {
+	live := []int{}
+	for _, pid := range holders {
+		if processAlive(pid) {
+			live = append(live, pid)
+		}
+	}
+	return live
+}
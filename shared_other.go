@@ -0,0 +1,45 @@
+//go:build !unix
+
+package dockerharness
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"time"
+)
+
+const sharedLockTimeout = 5 * time.Minute
+
+// lockShared takes an exclusive, cross-process lock for the given shared
+// container key by exclusively creating a lock file, blocking until it
+// is available.
+func lockShared(dir string, key string) (func(), error) {
+	path := filepath.Join(dir, key+".lock")
+	start := time.Now()
+	for {
+		file, err := os.OpenFile(path, os.O_CREATE|os.O_EXCL|os.O_WRONLY, 0o644)
+		if err == nil {
+			file.Close()
+			return func() { os.Remove(path) }, nil
+		} else if !errors.Is(err, os.ErrExist) {
+			return nil, err
+		}
+
+		if time.Since(start) > sharedLockTimeout {
+			return nil, fmt.Errorf("timed out waiting for shared container lock %s", path)
+		}
+		time.Sleep(50 * time.Millisecond)
+	}
+}
+
+func processAlive(pid int) bool {
+	// FindProcess only succeeds for live processes on these platforms
+	process, err := os.FindProcess(pid)
+	if err != nil {
+		return false
+	}
+	process.Release()
+	return true
+}
@@ -0,0 +1,206 @@
+package dockerharness
+
+import (
+	"context"
+	"os"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestSharedContainerKey(t *testing.T) {
+	base := ContainerOptions{
+		Image: "postgres",
+		Ports: map[string]string{"5432": "", "5433": ""},
+		Env:   map[string]string{"POSTGRES_USER": "postgres", "POSTGRES_PASSWORD": "postgres"},
+	}
+
+	// The key must not depend on map ordering, and a blank
+	// tag must be treated as latest.
+	a := SharedContainerKey(base)
+	b := SharedContainerKey(ContainerOptions{
+		Image: "postgres",
+		Tag:   "latest",
+		Ports: map[string]string{"5433": "", "5432": ""},
+		Env:   map[string]string{"POSTGRES_PASSWORD": "postgres", "POSTGRES_USER": "postgres"},
+	})
+	assert.Equal(t, a, b)
+
+	// Neither do the name nor how each process waits for it
+	named := base
+	named.Name = "other"
+	named.WaitHealthy = true
+	assert.Equal(t, a, SharedContainerKey(named))
+
+	// Any change to the container itself must produce a new key
+	changes := map[string]func(*ContainerOptions){
+		"tag":         func(o *ContainerOptions) { o.Tag = "12" },
+		"env":         func(o *ContainerOptions) { o.Env = map[string]string{"POSTGRES_PASSWORD": "other"} },
+		"ports":       func(o *ContainerOptions) { o.Ports = map[string]string{"5432": "15432"} },
+		"cmd":         func(o *ContainerOptions) { o.Cmd = []string{"postgres", "-c", "fsync=off"} },
+		"network":     func(o *ContainerOptions) { o.Network = "backend" },
+		"aliases":     func(o *ContainerOptions) { o.Aliases = []string{"db"} },
+		"host":        func(o *ContainerOptions) { o.HostGateway = true },
+		"extra hosts": func(o *ContainerOptions) { o.ExtraHosts = []string{"api:10.0.0.1"} },
+		"mounts":      func(o *ContainerOptions) { o.Mounts = []Mount{{Type: "tmpfs", Target: "/var/lib/postgresql/data"}} },
+		"resources":   func(o *ContainerOptions) { o.Resources = Resources{Memory: 256 << 20} },
+		"healthcheck": func(o *ContainerOptions) { o.Healthcheck = &Healthcheck{Disable: true} },
+	}
+	for name, change := range changes {
+		options := base
+		change(&options)
+		assert.NotEqual(t, a, SharedContainerKey(options), name)
+	}
+}
+
+func TestSharedContainerOptions(t *testing.T) {
+	_, err := NewContainerWithOptions(ContainerOptions{
+		Image:     "postgres",
+		HostPorts: &HostPorts{},
+		Shared:    true,
+	})
+	require.NotNil(t, err)
+}
+
+func TestSharedContainerHeld(t *testing.T) {
+	t.Setenv(SharedDirEnv, t.TempDir())
+	dir, err := sharedDir()
+	require.Nil(t, err)
+	key := SharedContainerKey(ContainerOptions{Image: "redis"})
+
+	held, err := SharedContainerHeld(key)
+	require.Nil(t, err)
+	assert.False(t, held)
+
+	require.Nil(t, writeSharedState(dir, key, &sharedState{ContainerID: "abc", Holders: []int{1 << 30}}))
+	held, err = SharedContainerHeld(key)
+	require.Nil(t, err)
+	assert.False(t, held)
+
+	require.Nil(t, writeSharedState(dir, key, &sharedState{ContainerID: "abc", Holders: []int{os.Getpid()}}))
+	held, err = SharedContainerHeld(key)
+	require.Nil(t, err)
+	assert.True(t, held)
+}
+
+func TestSharedContainerStop(t *testing.T) {
+	t.Setenv(SharedDirEnv, t.TempDir())
+	dir, err := sharedDir()
+	require.Nil(t, err)
+
+	c := &Container{id: "abc", shared: true, sharedKey: SharedContainerKey(ContainerOptions{Image: "redis"})}
+
+	// Another live process holds the container
+	require.Nil(t, writeSharedState(dir, c.sharedKey, &sharedState{
+		ContainerID: "abc",
+		Holders:     []int{os.Getpid(), os.Getppid()},
+	}))
+	assert.ErrorIs(t, c.Stop(0), ErrSharedContainerInUse)
+	assert.False(t, c.stopping.Load())
+
+	// Only this process, and holders that have exited, do not
+	require.Nil(t, writeSharedState(dir, c.sharedKey, &sharedState{
+		ContainerID: "abc",
+		Holders:     []int{os.Getpid(), os.Getpid(), 1 << 30},
+	}))
+	assert.Nil(t, c.checkSharedStop())
+}
+
+func TestSharedState(t *testing.T) {
+	dir := t.TempDir()
+	key := SharedContainerKey(ContainerOptions{Image: "redis"})
+
+	// A missing state file reads as an empty state
+	state, err := readSharedState(dir, key)
+	require.Nil(t, err)
+	assert.Empty(t, state.ContainerID)
+	assert.Empty(t, state.Holders)
+
+	unlock, err := lockShared(dir, key)
+	require.Nil(t, err)
+
+	err = writeSharedState(dir, key, &sharedState{
+		ContainerID: "abc",
+		Name:        "docker-harness-shared-abc",
+		Ports:       map[string]string{"6379": "49000"},
+		Holders:     []int{os.Getpid(), os.Getpid()},
+	})
+	require.Nil(t, err)
+	unlock()
+
+	state, err = readSharedState(dir, key)
+	require.Nil(t, err)
+	assert.Equal(t, "abc", state.ContainerID)
+	assert.Equal(t, "49000", state.Ports["6379"])
+	assert.Len(t, state.Holders, 2)
+
+	err = removeSharedState(dir, key)
+	require.Nil(t, err)
+
+	// Removing an already removed state is not an error
+	err = removeSharedState(dir, key)
+	require.Nil(t, err)
+}
+
+func TestLiveHolders(t *testing.T) {
+	// Our own process is alive; a pid that cannot exist is not
+	holders := liveHolders([]int{os.Getpid(), 1 << 30})
+	assert.Equal(t, []int{os.Getpid()}, holders)
+}
+
+func TestSharedContainer(t *testing.T) {
+	t.Setenv(SharedDirEnv, t.TempDir())
+
+	env := map[string]string{
+		"POSTGRES_USER":     "postgres",
+		"POSTGRES_PASSWORD": "postgres",
+		"SHARED_TEST":       t.Name(),
+	}
+
+	first, err := NewContainerWithOptions(ContainerOptions{
+		Image:  "postgres",
+		Ports:  map[string]string{"5432": ""},
+		Env:    env,
+		Shared: true,
+	})
+	require.Nil(t, err)
+
+	err = first.Start()
+	require.Nil(t, err)
+	defer first.Cleanup()
+
+	// A second harness with the same configuration attaches to
+	// the same container rather than starting another
+	second, err := NewContainerWithOptions(ContainerOptions{
+		Image:  "postgres",
+		Ports:  map[string]string{"5432": ""},
+		Env:    env,
+		Shared: true,
+	})
+	require.Nil(t, err)
+
+	err = second.Start()
+	require.Nil(t, err)
+	defer second.Cleanup()
+
+	assert.Equal(t, first.GetContainerID(), second.GetContainerID())
+	assert.Equal(t, first.GetPorts(), second.GetPorts())
+
+	// Releasing one holder keeps the container running for
+	// the other
+	id := first.GetContainerID()
+	err = first.Cleanup()
+	require.Nil(t, err)
+
+	running, err := second.IsRunning()
+	require.Nil(t, err)
+	assert.True(t, running)
+
+	// Releasing the last holder removes the container
+	err = second.Cleanup()
+	require.Nil(t, err)
+
+	_, err = second.client.ContainerInspect(context.Background(), id)
+	assert.NotNil(t, err)
+}
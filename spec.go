@@ -0,0 +1,644 @@
+package dockerharness
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+
+	"gopkg.in/yaml.v3"
+)
+
+/*
+SpecError is a problem found in an environment spec, pointing at the
+line of the spec it was found on.
+*/
+type SpecError struct {
+	File    string
+	Line    int
+	Message string
+}
+
+func (e *SpecError) Error() string {
+	if e.Line > 0 {
+		return fmt.Sprintf("%s:%d: %s", e.File, e.Line, e.Message)
+	}
+	return fmt.Sprintf("%s: %s", e.File, e.Message)
+}
+
+// specModule is a preset for a well known image, mirroring the setup of
+// the matching database module.
+type specModule struct {
+	image string
+	ports []string
+	env   map[string]string
+	wait  []WaitStrategy
+}
+
+var specModules = map[string]specModule{
+	"postgres": {
+		image: "postgres",
+		ports: []string{"5432"},
+		env: map[string]string{
+			"POSTGRES_USER":     "postgres",
+			"POSTGRES_PASSWORD": "postgres",
+			"POSTGRES_DB":       "postgres",
+		},
+		// The server only listens on TCP once initialisation is done
+		wait: []WaitStrategy{WaitForExec("pg_isready", "-h", "127.0.0.1")},
+	},
+	"mysql": {
+		image: "mysql",
+		ports: []string{"3306"},
+		env: map[string]string{
+			"MYSQL_ROOT_PASSWORD": "mysql",
+			"MYSQL_DATABASE":      "mysql",
+		},
+		wait: []WaitStrategy{WaitForExec("sh", "-c", `mysqladmin ping -h 127.0.0.1 -uroot -p"$MYSQL_ROOT_PASSWORD" --silent`)},
+	},
+	"redis": {
+		image: "redis",
+		ports: []string{"6379"},
+		wait:  []WaitStrategy{WaitForExec("redis-cli", "ping")},
+	},
+	"memcached": {
+		image: "memcached",
+		ports: []string{"11211"},
+		wait:  []WaitStrategy{WaitForPort("11211")},
+	},
+}
+
+/*
+LoadSpec will read a YAML or JSON environment spec and return a stack
+of its containers, ready to be started. Relative bind mount paths in
+the spec are relative to the spec file. An example spec:
+
+	name: checkout-env
+	containers:
+	  db:
+	    module: postgres
+	    tag: "16"
+	  api:
+	    image: my-api
+	    ports: ["8080"]
+	    env:
+	      DATABASE_HOST: db
+	    mounts:
+	      - ./fixtures:/fixtures:ro
+	    wait:
+	      - http: {port: "8080", path: /health}
+	    depends_on:
+	      db: started
+
+Problems with the spec are reported as SpecErrors pointing at the line
+they were found on.
+*/
+func LoadSpec(path string) (*Stack, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	return ParseSpec(path, data)
+}
+
+/*
+ParseSpec will parse an environment spec as LoadSpec does. The name is
+used in errors, and its directory to resolve relative bind mounts.
+*/
+func ParseSpec(name string, data []byte) (*Stack, error) {
+	parser := &specParser{file: name, dir: filepath.Dir(name)}
+
+	root := &yaml.Node{}
+	if err := yaml.Unmarshal(data, root); err != nil {
+		return nil, &SpecError{File: name, Line: yamlErrorLine(err), Message: err.Error()}
+	}
+	if len(root.Content) == 0 {
+		return nil, &SpecError{File: name, Message: "spec is empty"}
+	}
+
+	options, ok := parser.parse(root.Content[0])
+	if !ok {
+		return nil, errors.Join(parser.errs...)
+	}
+
+	// The spec has been fully checked by now, so anything NewStack
+	// reports is a problem with the environment rather than the spec
+	stack, err := NewStack(options)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create the stack for %s: %w", name, err)
+	}
+
+	return stack, nil
+}
+
+type specParser struct {
+	file string
+	dir  string
+	errs []error
+	// dependencyNodes are the depends_on entries by container and
+	// dependency, to point dependency cycles at
+	dependencyNodes map[string]map[string]*yaml.Node
+}
+
+func (p *specParser) errorf(node *yaml.Node, format string, args ...any) {
+	p.errs = append(p.errs, &SpecError{File: p.file, Line: node.Line, Message: fmt.Sprintf(format, args...)})
+}
+
+func (p *specParser) parse(doc *yaml.Node) (StackOptions, bool) {
+	options := StackOptions{Containers: map[string]StackContainer{}}
+
+	fields := p.mapping(doc, "spec", "name", "containers", "wait_timeout")
+	if fields == nil {
+		return options, false
+	}
+
+	if node, ok := fields["name"]; ok {
+		options.Name, _ = p.scalar(node, "name")
+	}
+	if node, ok := fields["wait_timeout"]; ok {
+		options.WaitTimeout = p.duration(node, "wait_timeout")
+	}
+
+	containers, ok := fields["containers"]
+	if !ok {
+		p.errorf(doc, "spec must define containers")
+		return options, false
+	}
+	if containers.Kind != yaml.MappingNode || len(containers.Content) == 0 {
+		p.errorf(containers, "containers must be a mapping of container names to containers")
+		return options, false
+	}
+
+	names := map[string]bool{}
+	for i := 0; i < len(containers.Content); i += 2 {
+		names[containers.Content[i].Value] = true
+	}
+
+	for i := 0; i < len(containers.Content); i += 2 {
+		key, node := containers.Content[i], containers.Content[i+1]
+		if key.Value == "" {
+			p.errorf(key, "container name cannot be blank")
+			continue
+		}
+		if sc, ok := p.container(key.Value, node, names); ok {
+			options.Containers[key.Value] = sc
+		}
+	}
+	if len(p.errs) > 0 {
+		return options, false
+	}
+
+	// Point at the depends_on entry that closes the cycle
+	if cycle := findDependencyCycle(options.Containers); cycle != nil {
+		from, to := cycle[len(cycle)-2], cycle[len(cycle)-1]
+		p.errorf(p.dependencyNodes[from][to], "dependency cycle: %s", strings.Join(cycle, " -> "))
+		return options, false
+	}
+
+	return options, true
+}
+
+func (p *specParser) container(name string, node *yaml.Node, names map[string]bool) (StackContainer, bool) {
+	fields := p.mapping(node, fmt.Sprintf("container %s", name),
+		"module", "image", "tag", "command", "env", "ports", "mounts", "healthcheck", "wait", "wait_healthy", "wait_timeout", "depends_on")
+	if fields == nil {
+		return StackContainer{}, false
+	}
+
+	options := ContainerOptions{
+		Env:   map[string]string{},
+		Ports: map[string]string{},
+	}
+
+	// Start from the module's preset, if any
+	if moduleNode, ok := fields["module"]; ok {
+		moduleName, _ := p.scalar(moduleNode, "module")
+		module, ok := specModules[moduleName]
+		if !ok {
+			p.errorf(moduleNode, "container %s has unknown module %q; expected one of %s", name, moduleName, strings.Join(sortedKeys(specModules), ", "))
+		}
+		options.Image = module.image
+		for _, port := range module.ports {
+			options.Ports[port] = ""
+		}
+		for k, v := range module.env {
+			options.Env[k] = v
+		}
+		options.WaitFor = module.wait
+	}
+
+	if node, ok := fields["image"]; ok {
+		options.Image, _ = p.scalar(node, "image")
+	}
+	if _, ok := fields["module"]; !ok && options.Image == "" {
+		p.errorf(node, "container %s must set an image or a module", name)
+	}
+	if node, ok := fields["tag"]; ok {
+		options.Tag, _ = p.scalar(node, "tag")
+	}
+	if node, ok := fields["command"]; ok {
+		options.Cmd = p.strings(node, "command")
+	}
+	if node, ok := fields["env"]; ok {
+		for k, v := range p.stringMap(node, "env") {
+			options.Env[k] = v
+		}
+	}
+	if node, ok := fields["ports"]; ok {
+		for _, item := range p.sequence(node, "ports") {
+			port, ok := p.scalar(item, "port")
+			if !ok {
+				continue
+			}
+			// Ports are either "container" or "host:container"
+			host, private, found := strings.Cut(port, ":")
+			if !found {
+				host, private = "", port
+			}
+			if !validPort(private) || (host != "" && !validPort(host)) {
+				p.errorf(item, "invalid port %q; expected \"port\" or \"host:port\"", port)
+				continue
+			}
+			options.Ports[private] = host
+		}
+	}
+	if node, ok := fields["mounts"]; ok {
+		for _, item := range p.sequence(node, "mounts") {
+			if m, ok := p.mount(item); ok {
+				options.Mounts = append(options.Mounts, m)
+			}
+		}
+	}
+	if node, ok := fields["healthcheck"]; ok {
+		options.Healthcheck = p.healthcheck(node)
+	}
+	if node, ok := fields["wait"]; ok {
+		options.WaitFor = nil
+		for _, item := range p.sequence(node, "wait") {
+			if strategy, ok := p.wait(item); ok {
+				options.WaitFor = append(options.WaitFor, strategy)
+			}
+		}
+	}
+	if node, ok := fields["wait_healthy"]; ok {
+		options.WaitHealthy = p.bool(node, "wait_healthy")
+	}
+	if node, ok := fields["wait_timeout"]; ok {
+		options.WaitTimeout = p.duration(node, "wait_timeout")
+	}
+
+	dependsOn := map[string]DependencyCondition{}
+	if node, ok := fields["depends_on"]; ok {
+		dependsOn = p.dependencies(name, node, names)
+	}
+
+	if len(p.errs) > 0 {
+		return StackContainer{}, false
+	}
+
+	container, err := NewContainerWithOptions(options)
+	if err != nil {
+		p.errorf(node, "container %s: %v", name, err)
+		return StackContainer{}, false
+	}
+
+	return StackContainer{Container: container, DependsOn: dependsOn}, true
+}
+
+// mount parses either a "source:target[:ro]" string or a mapping of
+// type, source, target and read_only.
+func (p *specParser) mount(node *yaml.Node) (Mount, bool) {
+	m := Mount{}
+
+	if node.Kind == yaml.ScalarNode {
+		parts := strings.Split(node.Value, ":")
+		if len(parts) < 2 || len(parts) > 3 || (len(parts) == 3 && parts[2] != "ro" && parts[2] != "rw") {
+			p.errorf(node, "invalid mount %q; expected \"source:target\" or \"source:target:ro\"", node.Value)
+			return m, false
+		}
+		m.Source, m.Target = parts[0], parts[1]
+		m.ReadOnly = len(parts) == 3 && parts[2] == "ro"
+
+		// Like docker, a source that is not a path is a named volume
+		if !strings.ContainsAny(m.Source, `/\`) && !strings.HasPrefix(m.Source, ".") {
+			m.Type = "volume"
+		}
+	} else {
+		fields := p.mapping(node, "mount", "type", "source", "target", "read_only")
+		if fields == nil {
+			return m, false
+		}
+		if n, ok := fields["type"]; ok {
+			m.Type, _ = p.scalar(n, "type")
+		}
+		if n, ok := fields["source"]; ok {
+			m.Source, _ = p.scalar(n, "source")
+		}
+		if n, ok := fields["target"]; ok {
+			m.Target, _ = p.scalar(n, "target")
+		}
+		if n, ok := fields["read_only"]; ok {
+			m.ReadOnly = p.bool(n, "read_only")
+		}
+	}
+
+	switch m.Type {
+	case "", "bind":
+		// Bind mounts are relative to the spec file
+		if !filepath.IsAbs(m.Source) {
+			m.Source = filepath.Join(p.dir, m.Source)
+		}
+	case "volume", "tmpfs":
+	default:
+		p.errorf(node, "unknown mount type %q; expected bind, volume or tmpfs", m.Type)
+		return m, false
+	}
+	if m.Target == "" {
+		p.errorf(node, "mount must have a target")
+		return m, false
+	}
+
+	return m, true
+}
+
+// healthcheck parses a mapping of test, interval, timeout, start_period,
+// start_interval, retries and disable, as in a compose file. A test
+// given as a single string is run through the shell.
+func (p *specParser) healthcheck(node *yaml.Node) *Healthcheck {
+	fields := p.mapping(node, "healthcheck", "test", "interval", "timeout", "start_period", "start_interval", "retries", "disable")
+	if fields == nil {
+		return nil
+	}
+
+	healthcheck := &Healthcheck{}
+	if n, ok := fields["test"]; ok {
+		if n.Kind == yaml.ScalarNode {
+			healthcheck.Test = []string{"CMD-SHELL", n.Value}
+		} else {
+			healthcheck.Test = p.strings(n, "test")
+		}
+	}
+	if n, ok := fields["interval"]; ok {
+		healthcheck.Interval = p.duration(n, "interval")
+	}
+	if n, ok := fields["timeout"]; ok {
+		healthcheck.Timeout = p.duration(n, "timeout")
+	}
+	if n, ok := fields["start_period"]; ok {
+		healthcheck.StartPeriod = p.duration(n, "start_period")
+	}
+	if n, ok := fields["start_interval"]; ok {
+		healthcheck.StartInterval = p.duration(n, "start_interval")
+	}
+	if n, ok := fields["retries"]; ok {
+		healthcheck.Retries = p.int(n, "retries")
+	}
+	if n, ok := fields["disable"]; ok {
+		healthcheck.Disable = p.bool(n, "disable")
+	}
+
+	if err := healthcheck.validate(); err != nil {
+		p.errorf(node, "invalid healthcheck: %v", err)
+		return nil
+	}
+	return healthcheck
+}
+
+// wait parses a single key mapping naming the kind of wait strategy.
+func (p *specParser) wait(node *yaml.Node) (WaitStrategy, bool) {
+	fields := p.mapping(node, "wait", "log", "port", "http", "exec")
+	if fields == nil {
+		return nil, false
+	}
+	if len(fields) != 1 {
+		p.errorf(node, "each wait must have exactly one of log, port, http or exec")
+		return nil, false
+	}
+
+	if n, ok := fields["log"]; ok {
+		pattern := ""
+		times := 0
+		if n.Kind == yaml.ScalarNode {
+			pattern = n.Value
+		} else if logFields := p.mapping(n, "log wait", "pattern", "times"); logFields != nil {
+			if pn, ok := logFields["pattern"]; ok {
+				pattern, _ = p.scalar(pn, "pattern")
+			}
+			if tn, ok := logFields["times"]; ok {
+				times = p.int(tn, "times")
+			}
+		}
+		re, err := regexp.Compile(pattern)
+		if err != nil || pattern == "" {
+			p.errorf(n, "invalid log pattern %q", pattern)
+			return nil, false
+		}
+		return &LogWait{Pattern: re, Occurrences: times}, true
+	}
+
+	if n, ok := fields["port"]; ok {
+		port, _ := p.scalar(n, "port")
+		if !validPort(port) {
+			p.errorf(n, "invalid port %q", port)
+			return nil, false
+		}
+		return WaitForPort(port), true
+	}
+
+	if n, ok := fields["http"]; ok {
+		httpFields := p.mapping(n, "http wait", "port", "path", "status")
+		if httpFields == nil {
+			return nil, false
+		}
+		wait := &HTTPWait{Path: "/"}
+		if pn, ok := httpFields["port"]; ok {
+			wait.Port, _ = p.scalar(pn, "port")
+		}
+		if !validPort(wait.Port) {
+			p.errorf(n, "http wait must have a valid port")
+			return nil, false
+		}
+		if pn, ok := httpFields["path"]; ok {
+			wait.Path, _ = p.scalar(pn, "path")
+		}
+		if sn, ok := httpFields["status"]; ok {
+			wait.Status = p.int(sn, "status")
+		}
+		return wait, true
+	}
+
+	cmd := p.strings(fields["exec"], "exec")
+	if len(cmd) == 0 {
+		p.errorf(fields["exec"], "exec wait must have a command")
+		return nil, false
+	}
+	return WaitForExec(cmd...), true
+}
+
+// dependencies parses either a list of container names, which wait for
+// them to start, or a mapping of container names to conditions.
+func (p *specParser) dependencies(name string, node *yaml.Node, names map[string]bool) map[string]DependencyCondition {
+	dependsOn := map[string]DependencyCondition{}
+	if p.dependencyNodes == nil {
+		p.dependencyNodes = map[string]map[string]*yaml.Node{}
+	}
+	p.dependencyNodes[name] = map[string]*yaml.Node{}
+
+	check := func(n *yaml.Node, dep string) bool {
+		if !names[dep] {
+			p.errorf(n, "container %s depends on unknown container %s", name, dep)
+			return false
+		}
+		p.dependencyNodes[name][dep] = n
+		return true
+	}
+
+	switch node.Kind {
+	case yaml.SequenceNode:
+		for _, item := range node.Content {
+			if dep, ok := p.scalar(item, "dependency"); ok && check(item, dep) {
+				dependsOn[dep] = DependencyStarted
+			}
+		}
+	case yaml.MappingNode:
+		for i := 0; i < len(node.Content); i += 2 {
+			key, value := node.Content[i], node.Content[i+1]
+			condition, ok := p.scalar(value, "condition")
+			if !ok || !check(key, key.Value) {
+				continue
+			}
+			switch DependencyCondition(condition) {
+			case DependencyStarted, DependencyHealthy, DependencyCompleted:
+				dependsOn[key.Value] = DependencyCondition(condition)
+			default:
+				p.errorf(value, "unknown dependency condition %q; expected started, healthy or completed_successfully", condition)
+			}
+		}
+	default:
+		p.errorf(node, "depends_on must be a list of containers or a mapping of containers to conditions")
+	}
+
+	return dependsOn
+}
+
+// mapping checks that the node is a mapping with only the allowed keys,
+// returning its values by key.
+func (p *specParser) mapping(node *yaml.Node, what string, allowed ...string) map[string]*yaml.Node {
+	if node.Kind != yaml.MappingNode {
+		p.errorf(node, "%s must be a mapping", what)
+		return nil
+	}
+
+	fields := map[string]*yaml.Node{}
+	for i := 0; i < len(node.Content); i += 2 {
+		key, value := node.Content[i], node.Content[i+1]
+		known := false
+		for _, a := range allowed {
+			if key.Value == a {
+				known = true
+				break
+			}
+		}
+		if !known {
+			p.errorf(key, "unknown field %q in %s", key.Value, what)
+			continue
+		}
+		if _, ok := fields[key.Value]; ok {
+			p.errorf(key, "duplicate field %q in %s", key.Value, what)
+			continue
+		}
+		fields[key.Value] = value
+	}
+
+	return fields
+}
+
+func (p *specParser) scalar(node *yaml.Node, what string) (string, bool) {
+	if node.Kind != yaml.ScalarNode {
+		p.errorf(node, "%s must be a string", what)
+		return "", false
+	}
+	return node.Value, true
+}
+
+func (p *specParser) sequence(node *yaml.Node, what string) []*yaml.Node {
+	if node.Kind != yaml.SequenceNode {
+		p.errorf(node, "%s must be a list", what)
+		return nil
+	}
+	return node.Content
+}
+
+func (p *specParser) strings(node *yaml.Node, what string) []string {
+	values := []string{}
+	for _, item := range p.sequence(node, what) {
+		if value, ok := p.scalar(item, what); ok {
+			values = append(values, value)
+		}
+	}
+	return values
+}
+
+func (p *specParser) stringMap(node *yaml.Node, what string) map[string]string {
+	values := map[string]string{}
+	if node.Kind != yaml.MappingNode {
+		p.errorf(node, "%s must be a mapping", what)
+		return values
+	}
+	for i := 0; i < len(node.Content); i += 2 {
+		if value, ok := p.scalar(node.Content[i+1], what); ok {
+			values[node.Content[i].Value] = value
+		}
+	}
+	return values
+}
+
+func (p *specParser) int(node *yaml.Node, what string) int {
+	value, err := strconv.Atoi(node.Value)
+	if node.Kind != yaml.ScalarNode || err != nil {
+		p.errorf(node, "%s must be a whole number", what)
+	}
+	return value
+}
+
+func (p *specParser) bool(node *yaml.Node, what string) bool {
+	value, err := strconv.ParseBool(node.Value)
+	if node.Kind != yaml.ScalarNode || err != nil {
+		p.errorf(node, "%s must be true or false", what)
+	}
+	return value
+}
+
+func (p *specParser) duration(node *yaml.Node, what string) time.Duration {
+	value, err := time.ParseDuration(node.Value)
+	if node.Kind != yaml.ScalarNode || err != nil {
+		p.errorf(node, "%s must be a duration such as 30s", what)
+	}
+	return value
+}
+
+func validPort(port string) bool {
+	number, protocol, _ := strings.Cut(port, "/")
+	if protocol != "" && protocol != "tcp" && protocol != "udp" && protocol != "sctp" {
+		return false
+	}
+	n, err := strconv.Atoi(number)
+	return err == nil && n > 0 && n < 65536
+}
+
+var yamlLinePattern = regexp.MustCompile(`line (\d+)`)
+
+// yamlErrorLine pulls the line number out of a yaml syntax error.
+func yamlErrorLine(err error) int {
+	match := yamlLinePattern.FindStringSubmatch(err.Error())
+	if match == nil {
+		return 0
+	}
+	line, _ := strconv.Atoi(match[1])
+	return line
+}
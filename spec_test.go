@@ -0,0 +1,293 @@
+package dockerharness
+
+import (
+	"errors"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestLoadSpec(t *testing.T) {
+	stack, err := LoadSpec(filepath.Join("testdata", "spec", "env.yaml"))
+	require.Nil(t, err)
+	require.NotNil(t, stack)
+
+	assert.Equal(t, "spec-env", stack.GetName())
+	assert.Len(t, stack.GetContainers(), 4)
+
+	// Modules provide the image, ports, environment and wait
+	// strategy, with the spec's own settings on top
+	db := stack.GetContainer("db")
+	require.NotNil(t, db)
+	assert.Equal(t, "postgres", db.image)
+	assert.Equal(t, "16", db.tag)
+	assert.Contains(t, db.ports, "5432")
+	assert.Equal(t, "checkout", db.env["POSTGRES_DB"])
+	assert.Equal(t, "postgres", db.env["POSTGRES_USER"])
+	require.Len(t, db.waitFor, 1)
+	assert.IsType(t, &ExecWait{}, db.waitFor[0])
+
+	// Relative bind mounts resolve against the spec file
+	seed := stack.GetContainer("seed")
+	require.NotNil(t, seed)
+	require.Len(t, seed.mounts, 2)
+	expected, err := filepath.Abs(filepath.Join("testdata", "spec", "fixtures"))
+	require.Nil(t, err)
+	assert.Equal(t, expected, seed.mounts[0].Source)
+	assert.True(t, seed.mounts[0].ReadOnly)
+	assert.Equal(t, "tmpfs", seed.mounts[1].Type)
+
+	// Healthchecks given as a single string run through the shell
+	cache := stack.GetContainer("cache")
+	require.NotNil(t, cache)
+	require.NotNil(t, cache.healthcheck)
+	assert.Equal(t, []string{"CMD-SHELL", "redis-cli ping"}, cache.healthcheck.Test)
+	assert.Equal(t, time.Second, cache.healthcheck.Interval)
+	assert.Equal(t, 10, cache.healthcheck.Retries)
+
+	api := stack.GetContainer("api")
+	require.NotNil(t, api)
+	assert.Equal(t, "", api.ports["80"])
+	assert.Equal(t, "18080", api.ports["8080"])
+	require.Len(t, api.waitFor, 2)
+	assert.Equal(t, 200, api.waitFor[0].(*HTTPWait).Status)
+	assert.Equal(t, "80", api.waitFor[1].(*PortWait).Port)
+
+	assert.Equal(t, map[string]DependencyCondition{
+		"seed":  DependencyCompleted,
+		"cache": DependencyHealthy,
+	}, stack.containers["api"].DependsOn)
+}
+
+func TestLoadSpecJSON(t *testing.T) {
+	stack, err := LoadSpec(filepath.Join("testdata", "spec", "env.json"))
+	require.Nil(t, err)
+	require.NotNil(t, stack)
+
+	assert.Equal(t, "memcached", stack.GetContainer("cache").image)
+	assert.Equal(t, map[string]DependencyCondition{
+		"cache": DependencyStarted,
+	}, stack.containers["worker"].DependsOn)
+}
+
+func TestParseSpecErrors(t *testing.T) {
+	tests := []struct {
+		name    string
+		spec    string
+		line    int
+		message string
+	}{
+		{
+			name: "unknown field",
+			spec: `containers:
+  db:
+    module: postgres
+    imgae: postgres
+`,
+			line:    4,
+			message: `unknown field "imgae" in container db`,
+		},
+		{
+			name: "unknown module",
+			spec: `containers:
+  db:
+    module: oracle
+`,
+			line:    3,
+			message: `unknown module "oracle"`,
+		},
+		{
+			name: "missing image",
+			spec: `containers:
+  api:
+    tag: latest
+`,
+			line:    3,
+			message: "must set an image or a module",
+		},
+		{
+			name: "blank name",
+			spec: `containers:
+  "":
+    image: busybox
+`,
+			line:    2,
+			message: "container name cannot be blank",
+		},
+		{
+			name: "healthcheck without a test",
+			spec: `containers:
+  db:
+    image: postgres
+    healthcheck:
+      interval: 1s
+`,
+			line:    5,
+			message: "healthcheck test is required",
+		},
+		{
+			name: "unknown dependency",
+			spec: `containers:
+  api:
+    image: nginx
+    depends_on:
+      - db
+`,
+			line:    5,
+			message: "depends on unknown container db",
+		},
+		{
+			name: "bad condition",
+			spec: `containers:
+  db:
+    module: postgres
+  api:
+    image: nginx
+    depends_on:
+      db: whenever
+`,
+			line:    7,
+			message: `unknown dependency condition "whenever"`,
+		},
+		{
+			name: "bad port",
+			spec: `containers:
+  api:
+    image: nginx
+    ports:
+      - "http"
+`,
+			line:    5,
+			message: `invalid port "http"`,
+		},
+		{
+			name: "bad wait",
+			spec: `containers:
+  api:
+    image: nginx
+    wait:
+      - log: ready
+        port: "80"
+`,
+			line:    5,
+			message: "exactly one of log, port, http or exec",
+		},
+		{
+			name: "bad duration",
+			spec: `containers:
+  api:
+    image: nginx
+    wait_timeout: soon
+`,
+			line:    4,
+			message: "wait_timeout must be a duration",
+		},
+		{
+			name: "syntax error",
+			spec: `containers:
+  api:
+    image: [nginx
+`,
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			_, err := ParseSpec("spec.yaml", []byte(test.spec))
+			require.NotNil(t, err)
+
+			specErr := &SpecError{}
+			require.True(t, errors.As(err, &specErr), "expected a SpecError, got %v", err)
+			assert.Equal(t, "spec.yaml", specErr.File)
+			if test.line == 0 {
+				// Syntax errors carry whatever line the yaml
+				// parser reports
+				assert.Greater(t, specErr.Line, 0)
+			} else {
+				assert.Equal(t, test.line, specErr.Line)
+			}
+			assert.Contains(t, specErr.Message, test.message)
+		})
+	}
+}
+
+func TestParseSpecWait(t *testing.T) {
+	stack, err := ParseSpec("spec.yaml", []byte(`containers:
+  api:
+    image: my-api
+    ports: ["8080"]
+    wait:
+      - log: started
+      - log: {pattern: "ready$", times: 2}
+      - port: "8080"
+      - http: {port: "8080", path: health, status: 204}
+      - exec: [curl, -f, localhost:8080]
+`))
+	require.Nil(t, err)
+
+	waits := stack.GetContainers()["api"].waitFor
+	require.Len(t, waits, 5)
+	assert.Equal(t, "started", waits[0].(*LogWait).Pattern.String())
+	assert.Equal(t, "ready$", waits[1].(*LogWait).Pattern.String())
+	assert.Equal(t, 2, waits[1].(*LogWait).Occurrences)
+	assert.Equal(t, "8080", waits[2].(*PortWait).Port)
+	assert.Equal(t, &HTTPWait{Port: "8080", Path: "health", Status: 204}, waits[3])
+	assert.Equal(t, []string{"curl", "-f", "localhost:8080"}, waits[4].(*ExecWait).Cmd)
+
+	invalid := map[string]string{
+		"log: \"(\"":           `invalid log pattern "("`,
+		"http: {path: /}":      "http wait must have a valid port",
+		"exec: []":             "exec wait must have a command",
+		"tcp: \"8080\"":        `unknown field "tcp"`,
+		"log: {pattern: \"\"}": `invalid log pattern ""`,
+	}
+	for wait, message := range invalid {
+		_, err := ParseSpec("spec.yaml", []byte("containers:\n  api:\n    image: my-api\n    wait:\n      - "+wait+"\n"))
+		specErr := &SpecError{}
+		require.ErrorAs(t, err, &specErr, wait)
+		assert.Equal(t, 5, specErr.Line, wait)
+		assert.Contains(t, specErr.Message, message, wait)
+	}
+}
+
+func TestParseSpecCycle(t *testing.T) {
+	_, err := ParseSpec("spec.yaml", []byte(`containers:
+  a:
+    image: busybox
+    depends_on: [b]
+  b:
+    image: busybox
+    depends_on: [a]
+`))
+
+	// The cycle is reported at the dependency that closes it
+	specErr := &SpecError{}
+	require.ErrorAs(t, err, &specErr)
+	assert.Equal(t, 7, specErr.Line)
+	assert.Equal(t, "dependency cycle: a -> b -> a", specErr.Message)
+
+	_, err = ParseSpec("spec.yaml", []byte(`containers:
+  a:
+    image: busybox
+    depends_on:
+      a: healthy
+`))
+	require.ErrorAs(t, err, &specErr)
+	assert.Equal(t, 5, specErr.Line)
+}
+
+func TestLoadSpecStart(t *testing.T) {
+	stack, err := LoadSpec(filepath.Join("testdata", "spec", "env.json"))
+	require.Nil(t, err)
+
+	err = stack.Start()
+	require.Nil(t, err)
+	defer stack.Cleanup()
+
+	running, err := stack.IsRunning()
+	require.Nil(t, err)
+	assert.True(t, running)
+}
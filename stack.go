@@ -0,0 +1,473 @@
+package dockerharness
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"maps"
+	"slices"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/docker/docker/api/types/network"
+	docker "github.com/docker/docker/client"
+)
+
+const defaultStackWaitTimeout = 60 * time.Second
+
+/*
+DependencyCondition is what a stack container waits for on a dependency
+before it is started. These mirror the conditions docker compose offers
+for depends_on.
+*/
+type DependencyCondition string
+
+const (
+	// DependencyStarted waits for the dependency to be running.
+	DependencyStarted DependencyCondition = "started"
+	// DependencyHealthy waits for the dependency's healthcheck to
+	// report healthy.
+	DependencyHealthy DependencyCondition = "healthy"
+	// DependencyCompleted waits for the dependency to exit with a zero
+	// exit code, such as a migration container.
+	DependencyCompleted DependencyCondition = "completed_successfully"
+)
+
+type StackContainer struct {
+	Container *Container
+	DependsOn map[string]DependencyCondition
+}
+
+type StackOptions struct {
+	Name       string
+	Containers map[string]StackContainer
+	// WaitTimeout is how long to wait for any one dependency condition
+	// before giving up. Defaults to 60 seconds.
+	WaitTimeout time.Duration
+	// HostPorts makes ports exposed with ExposeHostPorts reachable from
+	// every container in the stack at host.harness.internal
+	HostPorts *HostPorts
+}
+
+/*
+Stack is a set of named containers sharing a network, started in
+dependency order without requiring docker compose. Each container can
+reach the others by their names within the stack.
+*/
+type Stack struct {
+	client      *docker.Client
+	name        string
+	containers  map[string]StackContainer
+	waitTimeout time.Duration
+	networkID   string
+	hostPorts   *HostPorts
+
+	lock sync.Mutex
+}
+
+/*
+NewStack will create a new stack from the given containers. Every
+dependency must name a container in the stack, and the dependencies
+must not form a cycle. If a name is not provided, a unique one will be
+generated; it is also used as the stack's network name, so containers
+must not already be set to join another network.
+*/
+func NewStack(options StackOptions) (*Stack, error) {
+	if len(options.Containers) == 0 {
+		return nil, errors.New("at least one container is required")
+	}
+
+	for name, sc := range options.Containers {
+		if name == "" {
+			return nil, errors.New("stack container name cannot be blank")
+		}
+		if sc.Container == nil {
+			return nil, fmt.Errorf("stack container %s has no container", name)
+		}
+		for dep, condition := range sc.DependsOn {
+			if _, ok := options.Containers[dep]; !ok {
+				return nil, fmt.Errorf("stack container %s depends on unknown container %s", name, dep)
+			}
+			switch condition {
+			case DependencyStarted, DependencyHealthy, DependencyCompleted:
+			default:
+				return nil, fmt.Errorf("stack container %s has unknown dependency condition %q for %s", name, condition, dep)
+			}
+		}
+	}
+
+	if cycle := findDependencyCycle(options.Containers); cycle != nil {
+		return nil, fmt.Errorf("stack has a dependency cycle: %s", strings.Join(cycle, " -> "))
+	}
+
+	client, err := docker.NewClientWithOpts(docker.FromEnv)
+	if err != nil {
+		return nil, err
+	}
+
+	name := options.Name
+	if name == "" {
+		name = generateComposeName()
+	}
+
+	waitTimeout := options.WaitTimeout
+	if waitTimeout == 0 {
+		waitTimeout = defaultStackWaitTimeout
+	}
+
+	// Containers already on a network of their own cannot also join the
+	// stack's, as a container is only attached to one
+	for containerName, sc := range options.Containers {
+		sc.Container.lock.Lock()
+		network := sc.Container.network
+		sc.Container.lock.Unlock()
+		if network != "" && network != name {
+			return nil, fmt.Errorf("stack container %s is already on network %s", containerName, network)
+		}
+	}
+
+	// Every container joins the stack network under its stack name
+	for containerName, sc := range options.Containers {
+		sc.Container.lock.Lock()
+		sc.Container.network = name
+		if !slices.Contains(sc.Container.aliases, containerName) {
+			sc.Container.aliases = append(sc.Container.aliases, containerName)
+		}
+		if options.HostPorts != nil {
+			sc.Container.hostPorts = options.HostPorts
+		}
+		sc.Container.lock.Unlock()
+	}
+
+	return &Stack{
+		client:      client,
+		name:        name,
+		containers:  options.Containers,
+		waitTimeout: waitTimeout,
+		hostPorts:   options.HostPorts,
+	}, nil
+}
+
+/*
+Start will create the stack network and start every container once its
+dependencies have met their conditions. Containers that do not depend
+on one another are started in parallel. If a container fails to start,
+the containers depending on it are not started.
+*/
+func (s *Stack) Start() error {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	if err := s.createNetwork(); err != nil {
+		return err
+	}
+
+	err := s.walk(false, func(name string) error {
+		sc := s.containers[name]
+		for _, dep := range sortedKeys(sc.DependsOn) {
+			if err := s.waitFor(dep, sc.DependsOn[dep]); err != nil {
+				return err
+			}
+		}
+		return sc.Container.Start()
+	})
+
+	// The stack is responsible for cleaning up its containers in order,
+	// so it takes their place in the registry
+	for _, sc := range s.containers {
+		Unregister(sc.Container)
+	}
+	Register(s)
+
+	return err
+}
+
+/*
+Stop will stop every container in the reverse of their start order, so
+that no container is stopped while something depending on it is still
+running.
+*/
+func (s *Stack) Stop(wait int) error {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	return s.walk(true, func(name string) error {
+		return s.containers[name].Container.Stop(wait)
+	})
+}
+
+/*
+Cleanup will remove every container in the reverse of their start
+order, and then remove the stack network.
+*/
+func (s *Stack) Cleanup() error {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	err := s.walk(true, func(name string) error {
+		return s.containers[name].Container.Cleanup()
+	})
+	err = errors.Join(err, s.removeNetwork())
+	if err != nil {
+		return err
+	}
+
+	Unregister(s)
+	return nil
+}
+
+/*
+IsRunning will return true if any container in the stack is running,
+false otherwise.
+*/
+func (s *Stack) IsRunning() (bool, error) {
+	for _, name := range sortedKeys(s.containers) {
+		running, err := s.containers[name].Container.IsRunning()
+		if err != nil {
+			return false, err
+		} else if running {
+			return true, nil
+		}
+	}
+
+	return false, nil
+}
+
+/*
+ExportEnv will publish where each container's ports can be reached,
+named after the container's name within the stack, such as DB_HOST and
+DB_ADDR_5432 for a container named "db".
+*/
+func (s *Stack) ExportEnv() (map[string]string, error) {
+	env := map[string]string{}
+	for _, name := range sortedKeys(s.containers) {
+		values, err := s.containers[name].Container.exportEnv(envName(name))
+		if err != nil {
+			return nil, fmt.Errorf("%s: %w", name, err)
+		}
+		maps.Copy(env, values)
+	}
+	return env, nil
+}
+
+func (s *Stack) GetName() string {
+	return s.name
+}
+
+func (s *Stack) GetContainer(name string) *Container {
+	sc, ok := s.containers[name]
+	if !ok {
+		return nil
+	}
+	return sc.Container
+}
+
+func (s *Stack) GetContainers() map[string]*Container {
+	containers := map[string]*Container{}
+	for name, sc := range s.containers {
+		containers[name] = sc.Container
+	}
+	return containers
+}
+
+// walk calls fn for every container, each in its own goroutine, once
+// every container it depends on has finished - or, in reverse, once
+// every container depending on it has finished. Going forward, a
+// failure stops everything depending on it; in reverse every container
+// is visited regardless so that teardown is as complete as possible.
+func (s *Stack) walk(reverse bool, fn func(name string) error) error {
+	// waitsOn maps each container to those that must finish before it
+	waitsOn := map[string][]string{}
+	for name, sc := range s.containers {
+		for dep := range sc.DependsOn {
+			if reverse {
+				waitsOn[dep] = append(waitsOn[dep], name)
+			} else {
+				waitsOn[name] = append(waitsOn[name], dep)
+			}
+		}
+	}
+
+	done := map[string]chan struct{}{}
+	failed := map[string]bool{}
+	for name := range s.containers {
+		done[name] = make(chan struct{})
+	}
+
+	errs := []error{}
+	lock := sync.Mutex{}
+	wg := sync.WaitGroup{}
+	for name := range s.containers {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			defer close(done[name])
+
+			for _, prior := range waitsOn[name] {
+				<-done[prior]
+			}
+
+			lock.Lock()
+			if !reverse {
+				for _, prior := range waitsOn[name] {
+					if failed[prior] {
+						failed[name] = true
+						lock.Unlock()
+						return
+					}
+				}
+			}
+			lock.Unlock()
+
+			if err := fn(name); err != nil {
+				lock.Lock()
+				failed[name] = true
+				errs = append(errs, fmt.Errorf("stack container %s: %w", name, err))
+				lock.Unlock()
+			}
+		}()
+	}
+	wg.Wait()
+
+	return errors.Join(errs...)
+}
+
+// waitFor blocks until the named container meets the given condition,
+// or the stack's wait timeout passes.
+func (s *Stack) waitFor(name string, condition DependencyCondition) error {
+	if condition == DependencyStarted {
+		// The walk has already waited for the dependency to start
+		return nil
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), s.waitTimeout)
+	defer cancel()
+
+	if condition == DependencyHealthy {
+		if err := s.containers[name].Container.WaitHealthy(ctx); err != nil {
+			return fmt.Errorf("dependency %s did not become healthy: %w", name, err)
+		}
+		return nil
+	}
+
+	id := s.containers[name].Container.GetContainerID()
+	for {
+		inspect, err := s.client.ContainerInspect(ctx, id)
+		if err != nil {
+			return fmt.Errorf("failed waiting for %s to be %s: %w", name, condition, err)
+		}
+
+		if inspect.State.Status == "exited" {
+			if inspect.State.ExitCode != 0 {
+				return fmt.Errorf("dependency %s exited with code %d", name, inspect.State.ExitCode)
+			}
+			return nil
+		}
+
+		select {
+		case <-ctx.Done():
+			return fmt.Errorf("timed out waiting for %s to be %s", name, condition)
+		case <-time.After(250 * time.Millisecond):
+		}
+	}
+}
+
+func (s *Stack) createNetwork() error {
+	if s.networkID != "" {
+		return nil
+	}
+
+	response, err := s.client.NetworkCreate(context.Background(), s.name, network.CreateOptions{
+		Driver: "bridge",
+		Labels: managedLabels(),
+	})
+	if err != nil {
+		return fmt.Errorf("failed to create stack network: %w", err)
+	}
+	s.networkID = response.ID
+
+	return nil
+}
+
+func (s *Stack) removeNetwork() error {
+	if s.networkID == "" {
+		return nil
+	}
+
+	// The host ports sidecar outlives the stack, so must leave its
+	// network first
+	if s.hostPorts != nil {
+		if err := s.hostPorts.Detach(s.name); err != nil {
+			return err
+		}
+	}
+
+	err := s.client.NetworkRemove(context.Background(), s.networkID)
+	if err != nil && !docker.IsErrNotFound(err) {
+		return fmt.Errorf("failed to remove stack network: %w", err)
+	}
+	s.networkID = ""
+
+	return nil
+}
+
+// findDependencyCycle returns the containers forming a dependency cycle,
+// starting and ending with the same container, or nil if there is none.
+func findDependencyCycle(containers map[string]StackContainer) []string {
+	const (
+		unvisited = iota
+		visiting
+		visited
+	)
+
+	state := map[string]int{}
+	path := []string{}
+
+	var visit func(name string) []string
+	visit = func(name string) []string {
+		state[name] = visiting
+		path = append(path, name)
+
+		for _, dep := range sortedKeys(containers[name].DependsOn) {
+			switch state[dep] {
+			case visiting:
+				// Trim the path down to where the cycle begins
+				for i, n := range path {
+					if n == dep {
+						cycle := append([]string{}, path[i:]...)
+						return append(cycle, dep)
+					}
+				}
+			case unvisited:
+				if cycle := visit(dep); cycle != nil {
+					return cycle
+				}
+			}
+		}
+
+		path = path[:len(path)-1]
+		state[name] = visited
+		return nil
+	}
+
+	for _, name := range sortedKeys(containers) {
+		if state[name] == unvisited {
+			if cycle := visit(name); cycle != nil {
+				return cycle
+			}
+		}
+	}
+
+	return nil
+}
+
+func sortedKeys[V any](m map[string]V) []string {
+	keys := make([]string, 0, len(m))
+	for k := range m {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	return keys
+}
@@ -0,0 +1,228 @@
+package dockerharness
+
+import (
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestStackDetectsCycles(t *testing.T) {
+	_, err := NewStack(StackOptions{
+		Containers: map[string]StackContainer{
+			"api": {
+				Container: newStackTestContainer(t),
+				DependsOn: map[string]DependencyCondition{"db": DependencyHealthy},
+			},
+			"db": {
+				Container: newStackTestContainer(t),
+				DependsOn: map[string]DependencyCondition{"migrate": DependencyCompleted},
+			},
+			"migrate": {
+				Container: newStackTestContainer(t),
+				DependsOn: map[string]DependencyCondition{"api": DependencyStarted},
+			},
+		},
+	})
+	require.NotNil(t, err)
+	assert.Contains(t, err.Error(), "api -> db -> migrate -> api")
+
+	// A container depending on itself is a cycle too
+	_, err = NewStack(StackOptions{
+		Containers: map[string]StackContainer{
+			"loop": {
+				Container: newStackTestContainer(t),
+				DependsOn: map[string]DependencyCondition{"loop": DependencyStarted},
+			},
+		},
+	})
+	require.NotNil(t, err)
+	assert.Contains(t, err.Error(), "loop -> loop")
+}
+
+func TestStackValidatesDependencies(t *testing.T) {
+	_, err := NewStack(StackOptions{
+		Containers: map[string]StackContainer{
+			"api": {
+				Container: newStackTestContainer(t),
+				DependsOn: map[string]DependencyCondition{"db": DependencyStarted},
+			},
+		},
+	})
+	assert.ErrorContains(t, err, "unknown container db")
+
+	_, err = NewStack(StackOptions{
+		Containers: map[string]StackContainer{
+			"api": {
+				Container: newStackTestContainer(t),
+				DependsOn: map[string]DependencyCondition{"db": "eventually"},
+			},
+			"db": {Container: newStackTestContainer(t)},
+		},
+	})
+	assert.ErrorContains(t, err, "unknown dependency condition")
+}
+
+func TestStackContainerNetworks(t *testing.T) {
+	networked, err := NewContainerWithOptions(ContainerOptions{
+		Image:   "alpine",
+		Network: "elsewhere",
+	})
+	require.Nil(t, err)
+	_, err = NewStack(StackOptions{
+		Containers: map[string]StackContainer{"api": {Container: networked}},
+	})
+	assert.ErrorContains(t, err, "already on network elsewhere")
+	assert.Equal(t, "elsewhere", networked.network)
+
+	// Adding a container to the same stack again does not repeat its
+	// alias
+	c := newStackTestContainer(t)
+	for range 2 {
+		_, err = NewStack(StackOptions{
+			Name:       "stack-networks",
+			Containers: map[string]StackContainer{"api": {Container: c}},
+		})
+		require.Nil(t, err)
+	}
+	assert.Equal(t, "stack-networks", c.network)
+	assert.Equal(t, []string{"api"}, c.aliases)
+}
+
+func TestStackWalkOrder(t *testing.T) {
+	// web and worker both depend on db, which depends on
+	// migrate; cache stands alone
+	stack, err := NewStack(StackOptions{
+		Containers: map[string]StackContainer{
+			"migrate": {Container: newStackTestContainer(t)},
+			"db": {
+				Container: newStackTestContainer(t),
+				DependsOn: map[string]DependencyCondition{"migrate": DependencyStarted},
+			},
+			"web": {
+				Container: newStackTestContainer(t),
+				DependsOn: map[string]DependencyCondition{"db": DependencyStarted},
+			},
+			"worker": {
+				Container: newStackTestContainer(t),
+				DependsOn: map[string]DependencyCondition{"db": DependencyStarted},
+			},
+			"cache": {Container: newStackTestContainer(t)},
+		},
+	})
+	require.Nil(t, err)
+
+	visit := func(reverse bool) map[string]int {
+		lock := sync.Mutex{}
+		order := map[string]int{}
+		err := stack.walk(reverse, func(name string) error {
+			time.Sleep(10 * time.Millisecond)
+			lock.Lock()
+			defer lock.Unlock()
+			order[name] = len(order)
+			return nil
+		})
+		require.Nil(t, err)
+		return order
+	}
+
+	order := visit(false)
+	assert.Less(t, order["migrate"], order["db"])
+	assert.Less(t, order["db"], order["web"])
+	assert.Less(t, order["db"], order["worker"])
+
+	order = visit(true)
+	assert.Less(t, order["web"], order["db"])
+	assert.Less(t, order["worker"], order["db"])
+	assert.Less(t, order["db"], order["migrate"])
+}
+
+func TestStackWalkStopsDependents(t *testing.T) {
+	stack, err := NewStack(StackOptions{
+		Containers: map[string]StackContainer{
+			"db": {Container: newStackTestContainer(t)},
+			"web": {
+				Container: newStackTestContainer(t),
+				DependsOn: map[string]DependencyCondition{"db": DependencyStarted},
+			},
+		},
+	})
+	require.Nil(t, err)
+
+	visited := []string{}
+	err = stack.walk(false, func(name string) error {
+		visited = append(visited, name)
+		if name == "db" {
+			return assert.AnError
+		}
+		return nil
+	})
+	assert.ErrorIs(t, err, assert.AnError)
+	assert.Equal(t, []string{"db"}, visited)
+}
+
+func TestStackStartCleanup(t *testing.T) {
+	migrate, err := NewContainerWithOptions(ContainerOptions{
+		Image: "busybox",
+		Tag:   "1.36",
+		Cmd:   []string{"sh", "-c", "echo migrated"},
+	})
+	require.Nil(t, err)
+
+	db, err := NewContainerWithOptions(ContainerOptions{
+		Image: "busybox",
+		Tag:   "1.36",
+		Cmd:   []string{"sh", "-c", "sleep 300"},
+	})
+	require.Nil(t, err)
+
+	// The web container can only start if it can resolve the
+	// db container by its name on the stack network
+	web, err := NewContainerWithOptions(ContainerOptions{
+		Image: "busybox",
+		Tag:   "1.36",
+		Cmd:   []string{"sh", "-c", "ping -c 1 db && sleep 300"},
+	})
+	require.Nil(t, err)
+
+	stack, err := NewStack(StackOptions{
+		Name: composeTestName(t),
+		Containers: map[string]StackContainer{
+			"migrate": {Container: migrate},
+			"db": {
+				Container: db,
+				DependsOn: map[string]DependencyCondition{"migrate": DependencyCompleted},
+			},
+			"web": {
+				Container: web,
+				DependsOn: map[string]DependencyCondition{"db": DependencyStarted},
+			},
+		},
+	})
+	require.Nil(t, err)
+
+	err = stack.Start()
+	require.Nil(t, err)
+	defer stack.Cleanup()
+
+	running, err := stack.IsRunning()
+	require.Nil(t, err)
+	assert.True(t, running)
+
+	err = stack.Cleanup()
+	require.Nil(t, err)
+
+	running, err = stack.IsRunning()
+	require.Nil(t, err)
+	assert.False(t, running)
+}
+
+func newStackTestContainer(t *testing.T) *Container {
+	t.Helper()
+
+	container, err := NewContainerWithOptions(ContainerOptions{Image: "busybox"})
+	require.Nil(t, err)
+	return container
+}
@@ -0,0 +1,241 @@
+package dockerharness
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"regexp"
+	"strings"
+	"time"
+)
+
+const (
+	defaultWaitTimeout  = 60 * time.Second
+	defaultWaitInterval = 250 * time.Millisecond
+)
+
+/*
+WaitStrategy decides when a started container is ready to be used.
+WaitUntilReady should block until the target is ready, returning an
+error if it never becomes ready before the context is done.
+*/
+type WaitStrategy interface {
+	WaitUntilReady(ctx context.Context, target WaitTarget) error
+}
+
+/*
+WaitTarget is what a WaitStrategy checks readiness against - a single
+running container.
+*/
+type WaitTarget interface {
+	// HostAddress returns the host:port a private port is published on.
+	HostAddress(ctx context.Context, port string) (string, error)
+	// Logs returns the output of the container so far.
+	Logs(ctx context.Context) (string, error)
+	// Exec runs a command inside the container.
+	Exec(ctx context.Context, cmd []string) (ExecResult, error)
+}
+
+type ExecResult struct {
+	ExitCode int
+	Stdout   string
+	Stderr   string
+}
+
+type LogWait struct {
+	Pattern *regexp.Regexp
+	// Occurrences is how many times the pattern must appear; defaults
+	// to once.
+	Occurrences int
+}
+
+/*
+WaitForLog will wait until the container's logs match the regular
+expression. It panics if the pattern does not compile, like
+regexp.MustCompile.
+*/
+func WaitForLog(pattern string) *LogWait {
+	return &LogWait{Pattern: regexp.MustCompile(pattern)}
+}
+
+/*
+Times will require the pattern to appear in the logs at least n times,
+for images that log the same message more than once while starting.
+*/
+func (w *LogWait) Times(n int) *LogWait {
+	w.Occurrences = n
+	return w
+}
+
+func (w *LogWait) WaitUntilReady(ctx context.Context, target WaitTarget) error {
+	occurrences := w.Occurrences
+	if occurrences <= 0 {
+		occurrences = 1
+	}
+
+	return poll(ctx, func() error {
+		logs, err := target.Logs(ctx)
+		if err != nil {
+			return err
+		}
+		if found := len(w.Pattern.FindAllStringIndex(logs, -1)); found < occurrences {
+			return fmt.Errorf("log pattern %q found %d of %d times", w.Pattern, found, occurrences)
+		}
+		return nil
+	})
+}
+
+type PortWait struct {
+	Port string
+}
+
+/*
+WaitForPort will wait until a TCP connection can be made to the host
+address the private port is published on.
+*/
+func WaitForPort(port string) *PortWait {
+	return &PortWait{Port: port}
+}
+
+func (w *PortWait) WaitUntilReady(ctx context.Context, target WaitTarget) error {
+	return poll(ctx, func() error {
+		address, err := target.HostAddress(ctx, w.Port)
+		if err != nil {
+			return err
+		}
+
+		dialer := net.Dialer{Timeout: time.Second}
+		conn, err := dialer.DialContext(ctx, "tcp", address)
+		if err != nil {
+			return err
+		}
+		return conn.Close()
+	})
+}
+
+type HTTPWait struct {
+	Port string
+	Path string
+	// Status is the response status to wait for; any 2xx status is
+	// accepted if it is zero.
+	Status int
+}
+
+/*
+WaitForHTTP will wait until a GET of the path on the host address the
+private port is published on returns a successful status.
+*/
+func WaitForHTTP(port string, path string) *HTTPWait {
+	return &HTTPWait{Port: port, Path: path}
+}
+
+/*
+WithStatus will wait for a specific response status instead of any
+2xx status.
+*/
+func (w *HTTPWait) WithStatus(status int) *HTTPWait {
+	w.Status = status
+	return w
+}
+
+func (w *HTTPWait) WaitUntilReady(ctx context.Context, target WaitTarget) error {
+	client := &http.Client{Timeout: 5 * time.Second}
+
+	return poll(ctx, func() error {
+		address, err := target.HostAddress(ctx, w.Port)
+		if err != nil {
+			return err
+		}
+
+		path := w.Path
+		if !strings.HasPrefix(path, "/") {
+			path = "/" + path
+		}
+
+		request, err := http.NewRequestWithContext(ctx, http.MethodGet, fmt.Sprintf("http://%s%s", address, path), nil)
+		if err != nil {
+			return err
+		}
+		response, err := client.Do(request)
+		if err != nil {
+			return err
+		}
+		response.Body.Close()
+
+		if w.Status != 0 && response.StatusCode != w.Status {
+			return fmt.Errorf("GET %s returned %d, expected %d", path, response.StatusCode, w.Status)
+		} else if w.Status == 0 && (response.StatusCode < 200 || response.StatusCode > 299) {
+			return fmt.Errorf("GET %s returned %d", path, response.StatusCode)
+		}
+		return nil
+	})
+}
+
+type ExecWait struct {
+	Cmd []string
+}
+
+/*
+WaitForExec will wait until running the command inside the container
+exits with a zero exit code.
+*/
+func WaitForExec(cmd ...string) *ExecWait {
+	return &ExecWait{Cmd: cmd}
+}
+
+func (w *ExecWait) WaitUntilReady(ctx context.Context, target WaitTarget) error {
+	return poll(ctx, func() error {
+		result, err := target.Exec(ctx, w.Cmd)
+		if err != nil {
+			return err
+		}
+		if result.ExitCode != 0 {
+			return fmt.Errorf("%s exited with code %d: %s", strings.Join(w.Cmd, " "), result.ExitCode, strings.TrimSpace(result.Stderr))
+		}
+		return nil
+	})
+}
+
+// poll calls check until it succeeds or the context is done, returning
+// the last failure if it never succeeds.
+func poll(ctx context.Context, check func() error) error {
+	for {
+		err := check()
+		if err == nil {
+			return nil
+		}
+
+		select {
+		case <-ctx.Done():
+			return fmt.Errorf("%w: %w", ctx.Err(), err)
+		case <-time.After(defaultWaitInterval):
+		}
+	}
+}
+
+// waitForAll runs every strategy against the target, one after another,
+// within a single timeout.
+func waitForAll(target WaitTarget, strategies []WaitStrategy, timeout time.Duration) error {
+	if len(strategies) == 0 {
+		return nil
+	}
+	if timeout <= 0 {
+		timeout = defaultWaitTimeout
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+
+	for _, strategy := range strategies {
+		if strategy == nil {
+			return errors.New("wait strategy cannot be nil")
+		}
+		if err := strategy.WaitUntilReady(ctx, target); err != nil {
+			return fmt.Errorf("container was not ready: %w", err)
+		}
+	}
+
+	return nil
+}
@@ -0,0 +1,139 @@
+package dockerharness
+
+import (
+	"context"
+	"errors"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// waitTestTarget is a WaitTarget backed by fixed addresses, logs and
+// exec results, counting how often it is checked.
+type waitTestTarget struct {
+	addresses map[string]string
+	logs      func(calls int64) string
+	exec      func(calls int64) ExecResult
+	calls     atomic.Int64
+}
+
+func (t *waitTestTarget) HostAddress(ctx context.Context, port string) (string, error) {
+	address, ok := t.addresses[port]
+	if !ok {
+		return "", errors.New("port is not published")
+	}
+	return address, nil
+}
+
+func (t *waitTestTarget) Logs(ctx context.Context) (string, error) {
+	return t.logs(t.calls.Add(1)), nil
+}
+
+func (t *waitTestTarget) Exec(ctx context.Context, cmd []string) (ExecResult, error) {
+	return t.exec(t.calls.Add(1)), nil
+}
+
+func TestLogWait(t *testing.T) {
+	// The pattern has to appear twice; it only does on the second check
+	target := &waitTestTarget{logs: func(calls int64) string {
+		return strings.Repeat("ready to accept connections\n", int(calls))
+	}}
+
+	err := WaitForLog("ready to accept").Times(2).WaitUntilReady(context.Background(), target)
+	require.Nil(t, err)
+	assert.Equal(t, int64(2), target.calls.Load())
+
+	assert.Panics(t, func() { WaitForLog("(") })
+}
+
+func TestPortWait(t *testing.T) {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	require.Nil(t, err)
+	defer listener.Close()
+
+	target := &waitTestTarget{addresses: map[string]string{"5432": listener.Addr().String()}}
+	require.Nil(t, WaitForPort("5432").WaitUntilReady(context.Background(), target))
+
+	// A port that is not published never becomes ready
+	ctx, cancel := context.WithTimeout(context.Background(), 100*time.Millisecond)
+	defer cancel()
+	err = WaitForPort("6379").WaitUntilReady(ctx, target)
+	assert.ErrorIs(t, err, context.DeadlineExceeded)
+	assert.ErrorContains(t, err, "port is not published")
+}
+
+func TestHTTPWait(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/health":
+			w.WriteHeader(http.StatusNoContent)
+		case "/teapot":
+			w.WriteHeader(http.StatusTeapot)
+		default:
+			w.WriteHeader(http.StatusServiceUnavailable)
+		}
+	}))
+	defer server.Close()
+	target := &waitTestTarget{addresses: map[string]string{"8080": strings.TrimPrefix(server.URL, "http://")}}
+
+	// Any 2xx is ready, and the path's leading slash is optional
+	require.Nil(t, WaitForHTTP("8080", "health").WaitUntilReady(context.Background(), target))
+	require.Nil(t, WaitForHTTP("8080", "/teapot").WithStatus(http.StatusTeapot).WaitUntilReady(context.Background(), target))
+
+	ctx, cancel := context.WithTimeout(context.Background(), 100*time.Millisecond)
+	defer cancel()
+	err := WaitForHTTP("8080", "/").WaitUntilReady(ctx, target)
+	assert.ErrorIs(t, err, context.DeadlineExceeded)
+	assert.ErrorContains(t, err, "GET / returned 503")
+}
+
+func TestExecWait(t *testing.T) {
+	target := &waitTestTarget{exec: func(calls int64) ExecResult {
+		if calls < 3 {
+			return ExecResult{ExitCode: 1, Stderr: "no response\n"}
+		}
+		return ExecResult{}
+	}}
+
+	require.Nil(t, WaitForExec("pg_isready").WaitUntilReady(context.Background(), target))
+	assert.Equal(t, int64(3), target.calls.Load())
+}
+
+func TestPollTimeout(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), 100*time.Millisecond)
+	defer cancel()
+
+	// The last failure is reported along with the context's error
+	checks := 0
+	err := poll(ctx, func() error {
+		checks++
+		return errors.New("not yet")
+	})
+	assert.ErrorIs(t, err, context.DeadlineExceeded)
+	assert.ErrorContains(t, err, "not yet")
+	assert.Equal(t, 1, checks)
+}
+
+func TestWaitForAll(t *testing.T) {
+	target := &waitTestTarget{logs: func(int64) string { return "starting\n" }}
+
+	// Nothing to wait for is ready straight away
+	require.Nil(t, waitForAll(target, nil, time.Millisecond))
+
+	err := waitForAll(target, []WaitStrategy{nil}, time.Second)
+	assert.ErrorContains(t, err, "wait strategy cannot be nil")
+
+	// Every strategy shares the one timeout
+	start := time.Now()
+	err = waitForAll(target, []WaitStrategy{WaitForLog("ready"), WaitForLog("listening")}, 100*time.Millisecond)
+	assert.ErrorIs(t, err, context.DeadlineExceeded)
+	assert.ErrorContains(t, err, "container was not ready")
+	assert.Less(t, time.Since(start), time.Second)
+}